@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	ACTION_SECRET_KEY = "action_secret"
+
+	// Actions offered by the buttons on pull request posts.
+	ACTION_ASSIGN_ME    = "assign_me"
+	ACTION_CLAIM_REVIEW = "claim_review"
+)
+
+// getActionSecret returns the key the context of post action buttons is
+// signed with, generating it on first use.
+func (p *Plugin) getActionSecret() ([]byte, error) {
+	secret, err := p.api.KeyValueStore().Get(ACTION_SECRET_KEY)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) > 0 {
+		return secret, nil
+	}
+
+	secret = []byte(model.NewRandomString(32))
+	if err := p.api.KeyValueStore().Set(ACTION_SECRET_KEY, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func signAction(secret []byte, action, org, repo string, number int) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{action, org, repo, strconv.Itoa(number)}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// pullRequestActions builds the buttons that let whoever clicks them act on
+// the pull request with their own token, in an attachment colored for the
+// pull request's state. There are none unless the site URL is configured,
+// since Mattermost needs an absolute URL to call.
+func (p *Plugin) pullRequestActions(org, repo string, number int, state string) []*model.SlackAttachment {
+	siteURL := strings.TrimRight(p.config().SiteURL, "/")
+	if siteURL == "" {
+		return nil
+	}
+
+	secret, err := p.getActionSecret()
+	if err != nil {
+		fmt.Println("Error getting the action secret: " + err.Error())
+		return nil
+	}
+
+	action := func(name, id string) *model.PostAction {
+		return &model.PostAction{
+			Name: name,
+			Integration: &model.PostActionIntegration{
+				URL: siteURL + "/plugins/github/api/v1/pr/action",
+				Context: model.StringInterface{
+					"action":    id,
+					"org":       org,
+					"repo":      repo,
+					"number":    strconv.Itoa(number),
+					"signature": signAction(secret, id, org, repo, number),
+				},
+			},
+		}
+	}
+
+	gob.Register([]*model.SlackAttachment{})
+
+	return []*model.SlackAttachment{{
+		Color: p.config().colorForState(state),
+		Actions: []*model.PostAction{
+			action("I'll review", ACTION_CLAIM_REVIEW),
+			action("Assign me", ACTION_ASSIGN_ME),
+		},
+	}}
+}
+
+// handlePullRequestAction performs the action of a pull request post's button
+// with the GitHub token of the user who clicked it. The signature of the
+// context only proves the button was built by the plugin, not who clicked it,
+// so the user is taken from the Mattermost-User-Id header Mattermost sets for
+// an authenticated request, and the user in the body must match it.
+func (p *Plugin) handlePullRequestAction(w http.ResponseWriter, r *http.Request) {
+	var req model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	contextValue := func(key string) string {
+		value, _ := req.Context[key].(string)
+		return value
+	}
+	action, org, repo := contextValue("action"), contextValue("org"), contextValue("repo")
+	number, err := strconv.Atoi(contextValue("number"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid action")
+		return
+	}
+
+	userId := r.Header.Get("Mattermost-User-Id")
+	if userId == "" || (req.UserId != "" && req.UserId != userId) {
+		writeJSONError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	secret, appErr := p.getActionSecret()
+	if appErr != nil {
+		writeJSONError(w, http.StatusInternalServerError, appErr.Error())
+		return
+	}
+	if !hmac.Equal([]byte(contextValue("signature")), []byte(signAction(secret, action, org, repo, number))) {
+		writeJSONError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	response := &model.PostActionIntegrationResponse{}
+	if action == ACTION_CLAIM_REVIEW {
+		var pullRequest *github.PullRequest
+		var reviewers []*github.User
+		response.EphemeralText, pullRequest, reviewers = p.claimReview(userId, org, repo, number)
+		if pullRequest != nil {
+			response.Update = p.claimedPullRequestPost(org, repo, pullRequest, reviewers)
+		}
+	} else {
+		response.EphemeralText = p.performPullRequestAction(userId, action, org, repo, number)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(response.ToJson()))
+}
+
+// performPullRequestAction acts on the pull request as the user and describes
+// the outcome.
+func (p *Plugin) performPullRequestAction(userId, action, org, repo string, number int) string {
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return err.Error()
+	}
+	login := p.getGitHubLogin(userId)
+	if login == "" {
+		return "Your GitHub account is unknown. Use `/github register <token>` to connect it again."
+	}
+
+	githubClient := githubConnect(token)
+	prefix := fmt.Sprintf("Unable to update **%v/%v#%v**", org, repo, number)
+	switch action {
+	case ACTION_ASSIGN_ME:
+		if _, _, err := githubClient.Issues.AddAssignees(context.Background(), org, repo, number, []string{login}); err != nil {
+			return p.describeIssueError(userId, prefix, err)
+		}
+		return fmt.Sprintf("Assigned you to **%v/%v#%v**.", org, repo, number)
+	}
+	return "Unknown action."
+}
+
+// claimReview requests the user's own review on the pull request with their
+// token, unless it was already requested. It describes the outcome and, if the
+// reviewers changed, returns the pull request and its requested reviewers.
+func (p *Plugin) claimReview(userId, org, repo string, number int) (string, *github.PullRequest, []*github.User) {
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return err.Error(), nil, nil
+	}
+	login := p.getGitHubLogin(userId)
+	if login == "" {
+		return "Your GitHub account is unknown. Use `/github register <token>` to connect it again.", nil, nil
+	}
+
+	githubClient := githubConnect(token)
+	prefix := fmt.Sprintf("Unable to request your review on **%v/%v#%v**", org, repo, number)
+
+	reviewers, err := listRequestedReviewers(context.Background(), githubClient, org, repo, number)
+	if err != nil {
+		return p.describeIssueError(userId, prefix, err), nil, nil
+	}
+	for _, reviewer := range reviewers {
+		if strings.EqualFold(reviewer.GetLogin(), login) {
+			return fmt.Sprintf("Your review on **%v/%v#%v** was already requested.", org, repo, number), nil, nil
+		}
+	}
+
+	request := github.ReviewersRequest{Reviewers: []string{login}}
+	pullRequest, _, err := githubClient.PullRequests.RequestReviewers(context.Background(), org, repo, number, request)
+	if err != nil {
+		return p.describeIssueError(userId, prefix, err), nil, nil
+	}
+	reviewers = append(reviewers, &github.User{Login: github.String(login)})
+	return fmt.Sprintf("Requested your review on **%v/%v#%v**.", org, repo, number), pullRequest, reviewers
+}
+
+// claimedPullRequestPost rebuilds a compact pull request post to show the
+// reviewers asked to review it.
+func (p *Plugin) claimedPullRequestPost(org, repo string, pullRequest *github.PullRequest, requestedReviewers []*github.User) *model.Post {
+	message := compactPullRequestMessage(org+"/"+repo, pullRequest)
+	if reviewers := githubUserListToUsernames(requestedReviewers); len(reviewers) > 0 {
+		message += "\nReviewers: " + strings.Join(truncateList(reviewers, p.config().GetMaxListItems()), ", ")
+	}
+
+	post := p.newPost("", message)
+	post.Props["attachments"] = p.pullRequestActions(org, repo, pullRequest.GetNumber(), pullRequestState(pullRequest))
+	return post
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// MAX_SUBSCRIPTIONS_IMPORT_SIZE bounds the body of a subscriptions import.
+const MAX_SUBSCRIPTIONS_IMPORT_SIZE = 10 * 1024 * 1024
+
+// SubscriptionsImportResponse describes the subscriptions stored by an import.
+type SubscriptionsImportResponse struct {
+	Repositories  int `json:"repositories"`
+	Subscriptions int `json:"subscriptions"`
+}
+
+// requireSystemAdmin answers the request itself and returns false unless it
+// comes from a system admin.
+func (p *Plugin) requireSystemAdmin(w http.ResponseWriter, r *http.Request) bool {
+	userId := r.Header.Get("Mattermost-User-Id")
+	if userId == "" {
+		writeJSONError(w, http.StatusUnauthorized, "Not authorized")
+		return false
+	}
+	if !p.isSystemAdmin(userId) {
+		writeJSONError(w, http.StatusForbidden, "Forbidden")
+		return false
+	}
+	return true
+}
+
+// handleSubscriptionsExport returns the stored subscriptions as JSON, in the
+// form handleSubscriptionsImport accepts.
+func (p *Plugin) handleSubscriptionsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+
+	subscriptions, err := NewSubscriptionsFromKVStore(p.api.KeyValueStore())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Unable to load subscriptions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="github-subscriptions.json"`)
+	json.NewEncoder(w).Encode(subscriptions)
+}
+
+// handleSubscriptionsImport stores subscriptions exported from this or another
+// server. They replace the stored ones, or are merged into them with
+// ?mode=merge. Nothing is stored unless the whole import is valid.
+func (p *Plugin) handleSubscriptionsImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "replace" && mode != "merge" {
+		writeJSONError(w, http.StatusBadRequest, "The mode must be replace or merge")
+		return
+	}
+
+	var imported *Subscriptions
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(http.MaxBytesReader(w, r.Body, MAX_SUBSCRIPTIONS_IMPORT_SIZE)); err != nil {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "The subscriptions are too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "Bad request body")
+		return
+	}
+	if err := json.Unmarshal(body.Bytes(), &imported); err != nil || imported == nil {
+		writeJSONError(w, http.StatusBadRequest, "The subscriptions are not valid JSON")
+		return
+	}
+	imported.normalize()
+	if err := imported.Validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	subscriptions := imported
+	if mode == "merge" {
+		var err error
+		if subscriptions, err = NewSubscriptionsFromKVStore(p.api.KeyValueStore()); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Unable to load subscriptions")
+			return
+		}
+		subscriptions.Merge(imported)
+	}
+
+	if err := subscriptions.StoreInKVStore(p.api.KeyValueStore()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Unable to save subscriptions")
+		return
+	}
+
+	response := SubscriptionsImportResponse{Repositories: len(subscriptions.Repositories)}
+	for _, repositorySubscriptions := range subscriptions.Repositories {
+		response.Subscriptions += len(repositorySubscriptions)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
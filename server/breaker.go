@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	// BREAKER_FAILURE_THRESHOLD is the number of consecutive failed GitHub
+	// calls after which enrichment calls are skipped.
+	BREAKER_FAILURE_THRESHOLD = 5
+
+	// BREAKER_RETRY_INTERVAL is how long enrichment calls are skipped before
+	// one is let through to see whether GitHub has recovered.
+	BREAKER_RETRY_INTERVAL = time.Minute
+)
+
+// circuitBreaker stops calls to GitHub after repeated failures so that
+// webhooks aren't held up waiting on it during an outage. Its zero value is a
+// closed breaker.
+type circuitBreaker struct {
+	lock     sync.Mutex
+	name     string
+	failures int
+	openedAt time.Time
+}
+
+// allow reports whether a call should be made. While the breaker is open, one
+// call is let through every BREAKER_RETRY_INTERVAL.
+func (b *circuitBreaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.failures < BREAKER_FAILURE_THRESHOLD {
+		return true
+	}
+	if time.Since(b.openedAt) < BREAKER_RETRY_INTERVAL {
+		return false
+	}
+	b.openedAt = time.Now()
+	return true
+}
+
+// record updates the breaker with the outcome of a call. Errors GitHub
+// answered with a client error, such as a missing pull request, say nothing
+// about its health and are ignored.
+func (b *circuitBreaker) record(err error) {
+	if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil && errResp.Response.StatusCode < 500 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err == nil {
+		if b.failures >= BREAKER_FAILURE_THRESHOLD {
+			fmt.Printf("GitHub %v calls are succeeding again, closing the circuit breaker\n", b.name)
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures == BREAKER_FAILURE_THRESHOLD {
+		b.openedAt = time.Now()
+		fmt.Printf("%v consecutive GitHub %v calls failed, opening the circuit breaker: %v\n", b.failures, b.name, err.Error())
+	}
+}
@@ -0,0 +1,1421 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+func (p *Plugin) ExecuteCommand(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	config := p.config()
+	split := strings.Split(args.Command, " ")
+	command := split[0]
+	parameters := []string{}
+	action := ""
+	if len(split) > 1 {
+		action = split[1]
+	}
+	if len(split) > 2 {
+		parameters = split[2:]
+	}
+
+	if command != "/github" {
+		return nil, nil
+	}
+
+	switch action {
+	case "subscribe", "unsubscribe", "mute", "unmute":
+		if config.RestrictSubscriptions && !p.isChannelAdmin(args.UserId, args.ChannelId) {
+			return p.ephemeralResponse("You don't have permission to manage subscriptions in this channel."), nil
+		}
+	}
+
+	switch action {
+	case "subscribe":
+		if len(parameters) == 0 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+
+		return p.commandResponse(subscriptionResponseType(config), p.subscribe(args, parameters)), nil
+	case "unsubscribe":
+		if len(parameters) != 1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		subscriptions, err := NewSubscriptionsForChannelFromKVStore(p.api.KeyValueStore(), args.ChannelId)
+		if err != nil {
+			return p.ephemeralResponse("Unable to load subscriptions."), nil
+		}
+
+		if !subscriptions.Remove(args.ChannelId, parameters[0]) {
+			return p.ephemeralResponse(fmt.Sprintf("This channel is not subscribed to **%v**.", normalizeRepository(parameters[0]))), nil
+		}
+
+		subscriptions.StoreInKVStore(p.api.KeyValueStore())
+
+		return p.commandResponse(subscriptionResponseType(config), fmt.Sprintf("Unsubscribed this channel from **%v**.", normalizeRepository(parameters[0]))), nil
+	case "mute", "unmute":
+		subscriptions, err := NewSubscriptionsForChannelFromKVStore(p.api.KeyValueStore(), args.ChannelId)
+		if err != nil {
+			return p.ephemeralResponse("Unable to load subscriptions."), nil
+		}
+
+		text := "Muted GitHub notifications in this channel. Subscriptions are kept; use `/github unmute` to resume."
+		if action == "mute" {
+			subscriptions.Mute(args.ChannelId)
+		} else {
+			subscriptions.Unmute(args.ChannelId)
+			text = "Unmuted GitHub notifications in this channel."
+		}
+
+		if err := subscriptions.StoreInKVStore(p.api.KeyValueStore()); err != nil {
+			return p.ephemeralResponse("Unable to save subscriptions."), nil
+		}
+
+		return p.commandResponse(subscriptionResponseType(config), text), nil
+	case "register":
+		if len(parameters) != 1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		me, _, err := githubConnect(parameters[0]).Users.Get(context.Background(), "")
+		if err != nil {
+			return p.ephemeralResponse("Unable to verify the github token: " + err.Error()), nil
+		}
+
+		p.api.KeyValueStore().Set(args.UserId+GITHUB_TOKEN_KEY, []byte(parameters[0]))
+		p.storeGitHubLogin(args.UserId, me.GetLogin())
+		p.storeGitHubUserInfo(args.UserId, &GitHubUserInfo{ConnectedAt: model.GetMillis()})
+		return p.commandResponse(model.COMMAND_RESPONSE_TYPE_EPHEMERAL, fmt.Sprintf("Registered github token for **%v**.", me.GetLogin())), nil
+	case "deregister":
+		p.deleteUserToken(args.UserId)
+		return p.commandResponse(model.COMMAND_RESPONSE_TYPE_EPHEMERAL, "Deregistered github token."), nil
+	case "me":
+		info := p.getGitHubUserInfo(args.UserId)
+		if info == nil {
+			return p.ephemeralResponse("You haven't registered a github token. Use `/github register <token>` to connect your account."), nil
+		}
+
+		connectedAt := time.Unix(0, info.ConnectedAt*int64(time.Millisecond))
+		return p.ephemeralResponse(fmt.Sprintf("Connected to GitHub as **%v** since %v.", p.getGitHubLogin(args.UserId), connectedAt.Format(time.RFC1123))), nil
+	case "prs":
+		if len(parameters) != 1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		return p.ephemeralResponse(p.listPullRequests(args.UserId, parameters[0])), nil
+	case "repos":
+		if len(parameters) > 1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		filter := ""
+		if len(parameters) == 1 {
+			filter = parameters[0]
+		}
+		return p.ephemeralResponse(p.listOrgRepositories(args.UserId, filter)), nil
+	case "reviews":
+		if len(parameters) != 1 {
+			return p.ephemeralResponse("Usage: `/github reviews owner/repo` or `/github reviews org`"), nil
+		}
+		return p.ephemeralResponse(p.reviewOverview(args.UserId, parameters[0])), nil
+	case "search":
+		if len(parameters) == 0 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		return p.ephemeralResponse(p.search(args.UserId, strings.Join(parameters, " "))), nil
+	case "close", "reopen":
+		if len(parameters) != 1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+
+		state := "closed"
+		if action == "reopen" {
+			state = "open"
+		}
+		return p.ephemeralResponse(p.setIssueState(args.UserId, parameters[0], state)), nil
+	case "comment":
+		// Split the reference from the rest of the command by hand so that
+		// line breaks and spacing in the comment are kept.
+		rest := strings.TrimSpace(strings.Join(parameters, " "))
+		index := strings.IndexAny(rest, " \t\n")
+		if index == -1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		return p.ephemeralResponse(p.createComment(args.UserId, rest[:index], strings.TrimSpace(rest[index+1:]))), nil
+	case "assign":
+		if len(parameters) < 2 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		return p.ephemeralResponse(p.assign(args.UserId, parameters[0], parameters[1:])), nil
+	case "label":
+		if len(parameters) < 3 || (parameters[1] != "add" && parameters[1] != "remove") {
+			return p.ephemeralResponse("Usage: `/github label owner/repo#number add|remove <label>`"), nil
+		}
+		return p.ephemeralResponse(p.label(args.UserId, parameters[0], parameters[1] == "add", strings.Join(parameters[2:], " "))), nil
+	case "route", "unroute":
+		if len(parameters) != 1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		if !p.isSystemAdmin(args.UserId) {
+			return p.ephemeralResponse("Only system admins can route organizations."), nil
+		}
+
+		org := strings.ToLower(strings.Trim(parameters[0], "/"))
+		subscriptions, err := NewSubscriptionsForChannelFromKVStore(p.api.KeyValueStore(), args.ChannelId)
+		if err != nil {
+			return p.ephemeralResponse("Unable to load subscriptions."), nil
+		}
+
+		text := fmt.Sprintf("Events from **%v** repositories that no channel subscribes to will be posted to this channel.", org)
+		if action == "route" {
+			subscriptions.RouteOrg(org, args.ChannelId)
+		} else if subscriptions.UnrouteOrg(org) {
+			text = fmt.Sprintf("**%v** no longer has a default channel.", org)
+		} else {
+			return p.ephemeralResponse(fmt.Sprintf("**%v** has no default channel.", org)), nil
+		}
+
+		if err := subscriptions.StoreInKVStore(p.api.KeyValueStore()); err != nil {
+			return p.ephemeralResponse("Unable to save subscriptions."), nil
+		}
+		return p.ephemeralResponse(text), nil
+	case "debug":
+		if len(parameters) != 2 || (parameters[1] != "on" && parameters[1] != "off") {
+			return p.ephemeralResponse("Usage: `/github debug owner/repo on|off`"), nil
+		}
+		if !p.isSystemAdmin(args.UserId) {
+			return p.ephemeralResponse("Only system admins can turn on debug logging."), nil
+		}
+
+		repository := normalizeRepository(parameters[0])
+		on := parameters[1] == "on"
+		if err := p.setRepositoryDebug(repository, on); err != nil {
+			return p.ephemeralResponse("Unable to save the debug setting."), nil
+		}
+		if !on {
+			return p.ephemeralResponse(fmt.Sprintf("Turned off debug logging for **%v**.", repository)), nil
+		}
+		return p.ephemeralResponse(fmt.Sprintf("Webhooks for **%v** will be logged in detail for the next %v minutes.", repository, DEBUG_LOGGING_DURATION.Minutes())), nil
+	case "subscriptions":
+		if len(parameters) != 1 || parameters[0] != "all" {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		if !p.isSystemAdmin(args.UserId) {
+			return p.ephemeralResponse("Only system admins can list every subscription."), nil
+		}
+		return p.ephemeralResponse(p.listAllSubscriptions()), nil
+	case "notifications":
+		switch {
+		case len(parameters) == 0:
+			return p.ephemeralResponse(p.listNotifications(args.UserId)), nil
+		case parameters[0] == "read":
+			return p.ephemeralResponse(p.markNotificationsRead(args.UserId, parameters[1:])), nil
+		}
+		return p.ephemeralResponse("Wrong number of parameters."), nil
+	case "star", "unstar":
+		if len(parameters) != 1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		return p.ephemeralResponse(p.setStarred(args.UserId, parameters[0], action == "star")), nil
+	case "claim":
+		if len(parameters) != 1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		owner, repo, number, err := parseIssueReference(parameters[0])
+		if err != nil {
+			return p.ephemeralResponse(err.Error()), nil
+		}
+		text, _, _ := p.claimReview(args.UserId, owner, repo, number)
+		return p.ephemeralResponse(text), nil
+	case "refresh":
+		if len(parameters) != 1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		if text := p.refreshPullRequest(args.UserId, args.ChannelId, parameters[0]); text != "" {
+			return p.ephemeralResponse(text), nil
+		}
+		return &model.CommandResponse{}, nil
+	case "diff":
+		var options map[string]string
+		parameters, options = parseCommandOptions(parameters, "post")
+		if len(parameters) != 1 {
+			return p.ephemeralResponse("Wrong number of parameters."), nil
+		}
+		text, ok := p.describeDiff(args.UserId, parameters[0])
+		if !ok || options["post"] != "true" {
+			return p.ephemeralResponse(text), nil
+		}
+		if _, err := p.api.CreatePost(p.newPost(args.ChannelId, text)); err != nil {
+			return p.ephemeralResponse("Unable to post the diff summary: " + err.Error()), nil
+		}
+		return &model.CommandResponse{}, nil
+	case "snooze":
+		return p.ephemeralResponse(p.snooze(args.UserId, parameters)), nil
+	case "webhook":
+		if !p.isSystemAdmin(args.UserId) {
+			return p.ephemeralResponse("Only system admins can see the webhook URL."), nil
+		}
+		text := "Point your GitHub webhooks, with the content type `application/json` and the Webhook Secret setting, or the organization's secret, as their secret, at:\n" + p.webhookURL()
+		if config.SiteURL == "" {
+			text += "\nSet the Site URL setting to get the full URL."
+		}
+		return p.ephemeralResponse(text), nil
+	case "settings":
+		return p.ephemeralResponse(p.updateSettings(args.UserId, parameters)), nil
+	case "version":
+		return p.ephemeralResponse(describeVersion()), nil
+	case "ratelimit":
+		return p.ephemeralResponse(p.describeRateLimits(args.UserId)), nil
+	case "test":
+		if !p.isSystemAdmin(args.UserId) {
+			return p.ephemeralResponse("Only system admins can test the GitHub connection."), nil
+		}
+		return p.ephemeralResponse(p.testConnection()), nil
+	case "todo":
+		// Checked here so that an unconnected user hears why straight away
+		// rather than in a DM once the scan has failed.
+		if _, err := p.getUserToken(args.UserId); err != nil {
+			return p.ephemeralResponse(describeUserTokenError("Unable to check your pending reviews", err)), nil
+		}
+
+		var options map[string]string
+		parameters, options = parseCommandOptions(parameters)
+		bases := config.GetTodoBaseBranches()
+		if value, ok := options["base"]; ok {
+			var err error
+			if bases, err = parseBranchPatterns(value); err != nil {
+				return p.ephemeralResponse("Invalid --base: " + err.Error()), nil
+			}
+		}
+
+		var staleDays int
+		if len(parameters) > 0 && parameters[0] == "stale" {
+			staleDays = config.GetStaleTodoDays()
+			parameters = parameters[1:]
+			if len(parameters) > 0 && strings.HasSuffix(parameters[0], "d") {
+				days, err := strconv.Atoi(strings.TrimSuffix(parameters[0], "d"))
+				if err != nil || days < 1 {
+					return p.ephemeralResponse(fmt.Sprintf("**%v** is not a valid age. Give it in days, such as `14d`.", parameters[0])), nil
+				}
+				staleDays = days
+				parameters = parameters[1:]
+			}
+		}
+
+		orgs := config.GetOrgs()
+		if len(parameters) > 0 {
+			org, ok := config.FindOrg(parameters[0])
+			if !ok {
+				return p.ephemeralResponse(fmt.Sprintf("**%v** is not one of the configured organizations: %v.", parameters[0], strings.Join(orgs, ", "))), nil
+			}
+			orgs = []string{org}
+		}
+
+		if !p.startTodo(args.UserId) {
+			return p.ephemeralResponse("Your previous todo is still running. You'll get a message when it finishes."), nil
+		}
+
+		p.runInBackground(func(ctx context.Context) {
+			defer p.finishTodo(args.UserId)
+
+			ctx, cancel := context.WithTimeout(ctx, TODO_TIMEOUT)
+			defer cancel()
+
+			p.HandleTodo(ctx, args.UserId, orgs, staleDays, bases)
+		})
+		return p.ephemeralResponse("Checking GitHub for your pending PRs reviews. Get a :coffee:"), nil
+	}
+
+	return nil, nil
+}
+
+// subscribe subscribes the channel to each of the repositories and describes
+// the outcome for every one of them. A repository that can't be subscribed to
+// doesn't prevent the others from being added. System admins may name another
+// channel on the same team with --channel.
+func (p *Plugin) subscribe(args *model.CommandArgs, parameters []string) string {
+	// --mention-on takes two values, which parseCommandOptions can't handle.
+	var mentionOn []string
+	for i, parameter := range parameters {
+		if parameter == "--mention-on" {
+			if i+2 >= len(parameters) {
+				return "Usage: `--mention-on label:<name> @mention`"
+			}
+			mentionOn = parameters[i+1 : i+3]
+			parameters = append(parameters[:i:i], parameters[i+3:]...)
+			break
+		}
+	}
+
+	repositories, options := parseCommandOptions(parameters, "ignore-bots", "create-hook")
+	if len(repositories) == 0 {
+		return "Wrong number of parameters."
+	}
+	if options["create-hook"] == "true" && !p.isSystemAdmin(args.UserId) {
+		return "Only system admins can create webhooks."
+	}
+	ignoreBots := options["ignore-bots"] == "true"
+	authors := parseLogins(options["authors"])
+	excludeAuthors := parseLogins(options["exclude-authors"])
+	assignees := parseLogins(options["assignee"])
+	teams := parseTeams(options["teams"])
+
+	channelId := args.ChannelId
+	channelDescription := "this channel"
+	if name := options["channel"]; name != "" {
+		if !p.isSystemAdmin(args.UserId) {
+			return "Only system admins can subscribe other channels."
+		}
+
+		name = strings.TrimPrefix(name, "~")
+		channel, err := p.api.GetChannelByName(name, args.TeamId)
+		if err != nil {
+			return fmt.Sprintf("Unable to find the channel ~%v on this team.", name)
+		}
+		channelId = channel.Id
+		channelDescription = "~" + channel.Name
+	}
+
+	if len(teams) > 0 && !containsString(teams, p.channelTeamName(channelId)) {
+		return fmt.Sprintf("Unable to subscribe %v, since it isn't in any of these teams: %v.", channelDescription, strings.Join(teams, ", "))
+	}
+
+	events, err := parseEvents(options["events"])
+	if err != nil {
+		return "Invalid --events: " + err.Error()
+	}
+
+	format, err := parseFormat(options["format"])
+	if err != nil {
+		return "Invalid --format: " + err.Error()
+	}
+
+	bases, err := parseBranchPatterns(options["base"])
+	if err != nil {
+		return "Invalid --base: " + err.Error()
+	}
+
+	digest, err := parseDigest(options["digest"])
+	if err != nil {
+		return "Invalid --digest: " + err.Error()
+	}
+
+	var mentionLabels []string
+	var mention string
+	if mentionOn != nil {
+		if mentionLabels, mention, err = parseMentionOn(mentionOn[0], mentionOn[1]); err != nil {
+			return "Invalid --mention-on: " + err.Error()
+		}
+	}
+
+	subscriptions, err := NewSubscriptionsForChannelFromKVStore(p.api.KeyValueStore(), channelId)
+	if err != nil {
+		return "Unable to load subscriptions."
+	}
+
+	var subscribed []string
+	var lines []string
+	for _, repository := range repositories {
+		repository = normalizeRepository(repository)
+		if err := p.validateRepository(repository); err != nil {
+			lines = append(lines, fmt.Sprintf("Unable to subscribe to **%v**: %v", repository, err.Error()))
+			continue
+		}
+
+		added := subscriptions.Add(repository, &Subscription{
+			ChannelId:      channelId,
+			Events:         events,
+			Format:         format,
+			IgnoreBots:     ignoreBots,
+			Authors:        authors,
+			ExcludeAuthors: excludeAuthors,
+			Bases:          bases,
+			Assignees:      assignees,
+			Teams:          teams,
+			Digest:         digest,
+			Mention:        mention,
+			MentionLabels:  mentionLabels,
+		})
+		subscribed = append(subscribed, repository)
+		if added && strings.HasSuffix(repository, "/*") {
+			lines = append(lines, fmt.Sprintf("Subscribed %v to every repository of **%v**, including ones created later.", channelDescription, strings.TrimSuffix(repository, "/*")))
+		} else if added {
+			lines = append(lines, fmt.Sprintf("Subscribed %v to **%v**.", channelDescription, repository))
+		} else {
+			lines = append(lines, fmt.Sprintf("Already subscribed %v to **%v**. Its subscription now uses the options below.", channelDescription, repository))
+		}
+		if options["create-hook"] == "true" {
+			lines = append(lines, p.createWebhook(args.UserId, repository))
+		} else if warning := p.checkWebhook(args.UserId, repository); warning != "" {
+			lines = append(lines, warning)
+		}
+	}
+
+	if len(subscribed) > 0 {
+		if err := subscriptions.StoreInKVStore(p.api.KeyValueStore()); err != nil {
+			return "Unable to save subscriptions."
+		}
+		subscription := Subscription{Events: events, Format: format}
+		lines = append(lines, fmt.Sprintf("Events posted to %v: %v, in the %v format.", channelDescription, strings.Join(subscription.GetEvents(), ", "), subscription.GetFormat()))
+		if ignoreBots {
+			lines = append(lines, "Pull requests opened by bots won't be posted.")
+		}
+		if len(authors) > 0 {
+			lines = append(lines, fmt.Sprintf("Only pull requests opened by %v will be posted.", strings.Join(authors, ", ")))
+		} else if len(excludeAuthors) > 0 {
+			lines = append(lines, fmt.Sprintf("Pull requests opened by %v won't be posted.", strings.Join(excludeAuthors, ", ")))
+		}
+		if len(bases) > 0 {
+			lines = append(lines, fmt.Sprintf("Only pull requests into %v will be posted.", strings.Join(bases, ", ")))
+		}
+		if len(assignees) > 0 {
+			lines = append(lines, fmt.Sprintf("Only issues assigned to %v will be posted.", strings.Join(assignees, ", ")))
+		}
+		if len(teams) > 0 {
+			lines = append(lines, fmt.Sprintf("Events will only be posted while %v is in %v.", channelDescription, strings.Join(teams, ", ")))
+		}
+		if mention != "" {
+			lines = append(lines, fmt.Sprintf("Pull requests labeled %v will mention %v.", strings.Join(mentionLabels, ", "), mention))
+		}
+		if digest != "" {
+			lines = append(lines, fmt.Sprintf("Events will be summarized %v instead of being posted as they happen.", digest))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// parseCommandOptions separates --name value options from the positional
+// arguments of a command. The names in flags are options that take no value
+// and are set to "true" when present.
+func parseCommandOptions(parameters []string, flags ...string) ([]string, map[string]string) {
+	var arguments []string
+	options := map[string]string{}
+	for i := 0; i < len(parameters); i++ {
+		parameter := parameters[i]
+		if !strings.HasPrefix(parameter, "--") {
+			arguments = append(arguments, parameter)
+			continue
+		}
+
+		name := strings.TrimPrefix(parameter, "--")
+		isFlag := false
+		for _, flag := range flags {
+			if name == flag {
+				isFlag = true
+			}
+		}
+
+		if isFlag || i+1 >= len(parameters) {
+			options[name] = "true"
+		} else {
+			options[name] = parameters[i+1]
+			i++
+		}
+	}
+	return arguments, options
+}
+
+// parseRepository splits an owner/repo name into its owner and repository.
+func parseRepository(repository string) (string, string, error) {
+	parts := strings.Split(normalizeRepository(repository), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("repositories must be given as owner/repo")
+	}
+	return parts[0], parts[1], nil
+}
+
+// parseIssueReference splits an owner/repo#number reference to an issue or
+// pull request.
+func parseIssueReference(reference string) (string, string, int, error) {
+	index := strings.LastIndex(reference, "#")
+	if index == -1 {
+		return "", "", 0, fmt.Errorf("issues and pull requests must be given as owner/repo#number")
+	}
+
+	owner, repo, err := parseRepository(reference[:index])
+	if err != nil {
+		return "", "", 0, fmt.Errorf("issues and pull requests must be given as owner/repo#number")
+	}
+
+	number, err := strconv.Atoi(reference[index+1:])
+	if err != nil || number <= 0 {
+		return "", "", 0, fmt.Errorf("issues and pull requests must be given as owner/repo#number")
+	}
+
+	return owner, repo, number, nil
+}
+
+// validateRepository checks that the repository is in owner/repo form and that
+// it can be seen with the configured GitHub token.
+func (p *Plugin) validateRepository(repository string) error {
+	owner, repo, err := parseRepository(repository)
+	if err != nil {
+		return err
+	}
+
+	if repo == "*" {
+		isUser := false
+		if org, ok := p.config().FindOrg(owner); ok {
+			isUser = p.config().GithubOrgIsUser
+			owner = org
+		}
+		if _, err := listRepositories(context.Background(), p.githubClient, owner, isUser); err != nil {
+			return fmt.Errorf("organization not found or not accessible")
+		}
+		return nil
+	}
+
+	if _, _, err := p.githubClient.Repositories.Get(context.Background(), owner, repo); err != nil {
+		return fmt.Errorf("repository not found or not accessible")
+	}
+
+	return nil
+}
+
+// listAllSubscriptions describes every repository subscription on the server,
+// naming the subscribed channels.
+func (p *Plugin) listAllSubscriptions() string {
+	subscriptions, err := NewSubscriptionsFromKVStore(p.api.KeyValueStore())
+	if err != nil {
+		return "Unable to load subscriptions."
+	}
+
+	var repositories []string
+	for repository, repositorySubscriptions := range subscriptions.Repositories {
+		if len(repositorySubscriptions) > 0 {
+			repositories = append(repositories, repository)
+		}
+	}
+	if len(repositories) == 0 && len(subscriptions.OrgChannels) == 0 {
+		return "There are no subscriptions."
+	}
+	sort.Strings(repositories)
+
+	channelNames := map[string]string{}
+	channelName := func(channelId string) string {
+		if name, ok := channelNames[channelId]; ok {
+			return name
+		}
+		name := channelId + " (deleted channel)"
+		if channel, err := p.api.GetChannel(channelId); err == nil {
+			name = "~" + channel.Name
+			if team, err := p.api.GetTeam(channel.TeamId); err == nil {
+				name = team.Name + " " + name
+			}
+		}
+		channelNames[channelId] = name
+		return name
+	}
+
+	lines := []string{"| Repository | Channel | Events |", "|---|---|---|"}
+	for _, repository := range repositories {
+		for _, subscription := range subscriptions.Repositories[repository] {
+			name := channelName(subscription.ChannelId)
+			if subscriptions.IsMuted(subscription.ChannelId) {
+				name += " (muted)"
+			}
+			lines = append(lines, fmt.Sprintf("| %v | %v | %v |", repository, name, strings.Join(subscription.GetEvents(), ", ")))
+		}
+	}
+
+	var orgs []string
+	for org := range subscriptions.OrgChannels {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	for _, org := range orgs {
+		lines = append(lines, fmt.Sprintf("| %v/* (default) | %v | %v |", org, channelName(subscriptions.OrgChannels[org]), strings.Join(orgRouteEvents, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SEARCH_RESULTS_LIMIT caps the number of results /github search shows.
+const SEARCH_RESULTS_LIMIT = 10
+
+// search runs a GitHub issue and pull request search as the user and lists the
+// top results.
+func (p *Plugin) search(userId, query string) string {
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to search", err)
+	}
+
+	opt := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: SEARCH_RESULTS_LIMIT}}
+	result, _, err := githubConnect(token).Search.Issues(context.Background(), query, opt)
+	if err != nil {
+		return "Unable to search: " + err.Error()
+	}
+
+	if len(result.Issues) == 0 {
+		return fmt.Sprintf("No results for `%v`.", query)
+	}
+
+	lines := []string{fmt.Sprintf("Showing %v of %v results for `%v`:", len(result.Issues), result.GetTotal(), query)}
+	for _, issue := range result.Issues {
+		lines = append(lines, fmt.Sprintf("* [#%v %v](%v)", issue.GetNumber(), issue.GetTitle(), issue.GetHTMLURL()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// NOTIFICATIONS_LIMIT caps the number of notifications /github notifications
+// lists.
+const NOTIFICATIONS_LIMIT = 30
+
+// listNotifications lists the user's unread GitHub notifications.
+func (p *Plugin) listNotifications(userId string) string {
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to list notifications", err)
+	}
+	githubClient := githubConnect(token)
+
+	var notifications []*github.Notification
+	opt := &github.NotificationListOptions{ListOptions: github.ListOptions{PerPage: NOTIFICATIONS_LIMIT}}
+	truncated, err := paginate(&opt.ListOptions, NOTIFICATIONS_LIMIT, func(*github.ListOptions) (int, *github.Response, error) {
+		page, resp, err := githubClient.Activity.ListNotifications(context.Background(), opt)
+		notifications = append(notifications, page...)
+		return len(page), resp, err
+	})
+	if err != nil {
+		if p.handleGitHubAuthError(userId, err) {
+			return "GitHub rejected your token. Use `/github register <token>` to reconnect your account."
+		}
+		return "Unable to list notifications: " + err.Error()
+	}
+	if len(notifications) > NOTIFICATIONS_LIMIT {
+		notifications = notifications[:NOTIFICATIONS_LIMIT]
+		truncated = true
+	}
+
+	if len(notifications) == 0 {
+		return "You have no unread notifications."
+	}
+
+	lines := []string{
+		"Your unread notifications:",
+		"",
+		"| Repository | Reason | Subject |",
+		"|---|---|---|",
+	}
+	for _, notification := range notifications {
+		subject := notification.GetSubject()
+		title := strings.Replace(subject.GetTitle(), "|", "\\|", -1)
+		if url := notificationHTMLURL(subject.GetURL()); url != "" {
+			title = fmt.Sprintf("[%v](%v)", title, url)
+		}
+		lines = append(lines, fmt.Sprintf("| %v | %v | %v |", notification.GetRepository().GetFullName(), strings.Replace(notification.GetReason(), "_", " ", -1), title))
+	}
+	if truncated {
+		lines = append(lines, "", fmt.Sprintf("Showing the first %v.", NOTIFICATIONS_LIMIT))
+	}
+	lines = append(lines, "", "Use `/github notifications read` to mark them all as read.")
+	return strings.Join(lines, "\n")
+}
+
+// notificationHTMLURL turns the API URL of a notification's subject into the
+// URL of its page on GitHub.
+func notificationHTMLURL(apiURL string) string {
+	if apiURL == "" {
+		return ""
+	}
+	url := strings.Replace(apiURL, "://api.github.com/repos/", "://github.com/", 1)
+	url = strings.Replace(url, "/api/v3/repos/", "/", 1)
+	url = strings.Replace(url, "/commits/", "/commit/", 1)
+	return strings.Replace(url, "/pulls/", "/pull/", 1)
+}
+
+// markNotificationsRead marks the user's notifications as read, optionally
+// only those of one repository or those last updated before a date given with
+// --before, and says how many there were.
+func (p *Plugin) markNotificationsRead(userId string, parameters []string) string {
+	repositories, options := parseCommandOptions(parameters)
+	if len(repositories) > 1 {
+		return "Wrong number of parameters."
+	}
+
+	lastRead := time.Now()
+	if before := options["before"]; before != "" {
+		date, err := time.Parse("2006-01-02", before)
+		if err != nil {
+			return "Invalid --before: dates must be given as YYYY-MM-DD."
+		}
+		lastRead = date
+	}
+
+	var owner, repo string
+	if len(repositories) == 1 {
+		var err error
+		if owner, repo, err = parseRepository(repositories[0]); err != nil {
+			return err.Error()
+		}
+	}
+
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to mark notifications as read", err)
+	}
+	githubClient := githubConnect(token)
+	ctx := context.Background()
+
+	// GitHub doesn't say how many notifications it marked, so count the
+	// unread ones it is about to mark first.
+	count := 0
+	opt := &github.NotificationListOptions{Before: lastRead}
+	_, err = paginate(&opt.ListOptions, 0, func(*github.ListOptions) (int, *github.Response, error) {
+		var page []*github.Notification
+		var resp *github.Response
+		var err error
+		if repo == "" {
+			page, resp, err = githubClient.Activity.ListNotifications(ctx, opt)
+		} else {
+			page, resp, err = githubClient.Activity.ListRepositoryNotifications(ctx, owner, repo, opt)
+		}
+		count += len(page)
+		return len(page), resp, err
+	})
+	if err != nil {
+		if p.handleGitHubAuthError(userId, err) {
+			return "GitHub rejected your token. Use `/github register <token>` to reconnect your account."
+		}
+		return "Unable to mark notifications as read: " + err.Error()
+	}
+
+	if count == 0 {
+		return "You have no unread notifications to mark as read."
+	}
+
+	if repo == "" {
+		_, err = githubClient.Activity.MarkNotificationsRead(ctx, lastRead)
+	} else {
+		_, err = githubClient.Activity.MarkRepositoryNotificationsRead(ctx, owner, repo, lastRead)
+	}
+	if err != nil {
+		return "Unable to mark notifications as read: " + err.Error()
+	}
+
+	if repo == "" {
+		return fmt.Sprintf("Marked %v notifications as read.", count)
+	}
+	return fmt.Sprintf("Marked %v notifications from **%v/%v** as read.", count, owner, repo)
+}
+
+// PULL_REQUESTS_LIMIT caps the number of pull requests /github prs lists.
+const PULL_REQUESTS_LIMIT = 50
+
+// listPullRequests lists the open pull requests of the repository as a table,
+// using the user's token.
+func (p *Plugin) listPullRequests(userId, repository string) string {
+	owner, repo, err := parseRepository(repository)
+	if err != nil {
+		return err.Error()
+	}
+
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to list pull requests", err)
+	}
+	githubClient := githubConnect(token)
+
+	var pulls []*github.PullRequest
+	opt := &github.PullRequestListOptions{State: "open", ListOptions: github.ListOptions{PerPage: PULL_REQUESTS_LIMIT}}
+	truncated, err := paginate(&opt.ListOptions, PULL_REQUESTS_LIMIT, func(*github.ListOptions) (int, *github.Response, error) {
+		page, resp, err := githubClient.PullRequests.List(context.Background(), owner, repo, opt)
+		pulls = append(pulls, page...)
+		return len(page), resp, err
+	})
+	if err != nil {
+		return fmt.Sprintf("Unable to list pull requests for **%v/%v**. Check that the repository exists and that you have access to it.", owner, repo)
+	}
+	if len(pulls) > PULL_REQUESTS_LIMIT {
+		pulls = pulls[:PULL_REQUESTS_LIMIT]
+		truncated = true
+	}
+
+	if len(pulls) == 0 {
+		return fmt.Sprintf("There are no open pull requests in **%v/%v**.", owner, repo)
+	}
+
+	lines := []string{
+		fmt.Sprintf("Open pull requests in **%v/%v**:", owner, repo),
+		"",
+		"| # | Title | Author |",
+		"|---|---|---|",
+	}
+	for _, pull := range pulls {
+		title := strings.Replace(pull.GetTitle(), "|", "\\|", -1)
+		lines = append(lines, fmt.Sprintf("| [%v](%v) | %v | %v |", pull.GetNumber(), pull.GetHTMLURL(), title, pull.GetUser().GetLogin()))
+	}
+	if truncated {
+		lines = append(lines, "", fmt.Sprintf("Showing the first %v.", PULL_REQUESTS_LIMIT))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// REPOSITORIES_LIMIT caps the number of repositories /github repos lists.
+const REPOSITORIES_LIMIT = 100
+
+// listOrgRepositories lists the repositories of the configured organizations
+// whose names contain the filter, ignoring case, using the user's token.
+func (p *Plugin) listOrgRepositories(userId, filter string) string {
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to list repositories", err)
+	}
+	githubClient := githubConnect(token)
+	config := p.config()
+
+	var names []string
+	for _, org := range config.GetOrgs() {
+		repos, err := listRepositories(context.Background(), githubClient, org, config.GithubOrgIsUser)
+		if err != nil {
+			return p.describeIssueError(userId, fmt.Sprintf("Unable to list the repositories of **%v**", org), err)
+		}
+		for _, repo := range repos {
+			if strings.Contains(strings.ToLower(repo.GetFullName()), strings.ToLower(filter)) {
+				names = append(names, fmt.Sprintf("* [%v](%v)", repo.GetFullName(), repo.GetHTMLURL()))
+			}
+		}
+	}
+
+	if len(names) == 0 && filter != "" {
+		return fmt.Sprintf("No repositories match **%v**.", filter)
+	}
+	if len(names) == 0 {
+		return "There are no repositories you have access to."
+	}
+
+	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+	total := len(names)
+	if total > REPOSITORIES_LIMIT {
+		names = names[:REPOSITORIES_LIMIT]
+	}
+
+	lines := append([]string{"Repositories:"}, names...)
+	if total > REPOSITORIES_LIMIT {
+		lines = append(lines, "", fmt.Sprintf("Showing the first %v of %v. Add a filter to narrow them down.", REPOSITORIES_LIMIT, total))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// REVIEWS_LIMIT caps how many pull requests /github reviews looks at, since
+// each costs a request for its reviewers.
+const REVIEWS_LIMIT = 50
+
+// reviewOverview lists the open pull requests of a repository, or of every
+// repository of a configured organization, with their requested reviewers and
+// how many reviews each reviewer has pending, using the user's token.
+func (p *Plugin) reviewOverview(userId, target string) string {
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to list reviews", err)
+	}
+	githubClient := githubConnect(token)
+	ctx := context.Background()
+
+	var repositories []string
+	if strings.Contains(target, "/") {
+		owner, repo, err := parseRepository(target)
+		if err != nil {
+			return err.Error()
+		}
+		repositories = []string{owner + "/" + repo}
+	} else {
+		org, ok := p.config().FindOrg(target)
+		if !ok {
+			return fmt.Sprintf("**%v** is not one of the configured organizations: %v.", target, strings.Join(p.config().GetOrgs(), ", "))
+		}
+		repos, err := listRepositories(ctx, githubClient, org, p.config().GithubOrgIsUser)
+		if err != nil {
+			return p.describeIssueError(userId, fmt.Sprintf("Unable to list the repositories of **%v**", org), err)
+		}
+		for _, repo := range repos {
+			repositories = append(repositories, repo.GetFullName())
+		}
+	}
+
+	lines := []string{
+		fmt.Sprintf("Requested reviews in **%v**:", target),
+		"",
+		"| Pull request | Title | Reviewers |",
+		"|---|---|---|",
+	}
+	load := map[string]int{}
+	count := 0
+	truncated := false
+	for _, repository := range repositories {
+		if truncated || count == REVIEWS_LIMIT {
+			truncated = true
+			break
+		}
+		parts := strings.SplitN(repository, "/", 2)
+		var pulls []*github.PullRequest
+		opt := &github.PullRequestListOptions{State: "open"}
+		more, err := paginate(&opt.ListOptions, REVIEWS_LIMIT-count, func(*github.ListOptions) (int, *github.Response, error) {
+			page, resp, err := githubClient.PullRequests.List(ctx, parts[0], parts[1], opt)
+			pulls = append(pulls, page...)
+			return len(page), resp, err
+		})
+		if err != nil {
+			return p.describeIssueError(userId, fmt.Sprintf("Unable to list the pull requests of **%v**", repository), err)
+		}
+		if len(pulls) > REVIEWS_LIMIT-count {
+			pulls = pulls[:REVIEWS_LIMIT-count]
+			more = true
+		}
+		truncated = more
+
+		for _, pull := range pulls {
+			count++
+
+			users, err := listRequestedReviewers(ctx, githubClient, parts[0], parts[1], pull.GetNumber())
+			if err != nil {
+				return p.describeIssueError(userId, fmt.Sprintf("Unable to list the reviewers of **%v#%v**", repository, pull.GetNumber()), err)
+			}
+			reviewers := githubUserListToUsernames(users)
+			for _, reviewer := range reviewers {
+				load[reviewer]++
+			}
+			if len(reviewers) == 0 {
+				reviewers = []string{"_none_"}
+			}
+			title := strings.Replace(pull.GetTitle(), "|", "\\|", -1)
+			lines = append(lines, fmt.Sprintf("| [%v#%v](%v) | %v | %v |", repository, pull.GetNumber(), pull.GetHTMLURL(), title, strings.Join(reviewers, ", ")))
+		}
+	}
+
+	if count == 0 {
+		return fmt.Sprintf("There are no open pull requests in **%v**.", target)
+	}
+
+	reviewers := make([]string, 0, len(load))
+	for reviewer := range load {
+		reviewers = append(reviewers, reviewer)
+	}
+	sort.Slice(reviewers, func(i, j int) bool {
+		if load[reviewers[i]] != load[reviewers[j]] {
+			return load[reviewers[i]] > load[reviewers[j]]
+		}
+		return reviewers[i] < reviewers[j]
+	})
+	var summary []string
+	for _, reviewer := range reviewers {
+		summary = append(summary, fmt.Sprintf("%v (%v)", reviewer, load[reviewer]))
+	}
+	if len(summary) > 0 {
+		lines = append(lines, "", "Pending reviews: "+strings.Join(summary, ", "))
+	}
+	if truncated {
+		lines = append(lines, "", fmt.Sprintf("Showing the first %v pull requests.", REVIEWS_LIMIT))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// setIssueState closes or reopens the issue or pull request as the user and
+// describes its resulting state.
+func (p *Plugin) setIssueState(userId, reference, state string) string {
+	owner, repo, number, err := parseIssueReference(reference)
+	if err != nil {
+		return err.Error()
+	}
+
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to update the issue", err)
+	}
+
+	issue, _, err := githubConnect(token).Issues.Edit(context.Background(), owner, repo, number, &github.IssueRequest{State: github.String(state)})
+	if err != nil {
+		return p.describeIssueError(userId, fmt.Sprintf("Unable to update **%v/%v#%v**", owner, repo, number), err)
+	}
+
+	return fmt.Sprintf("[**%v/%v#%v**](%v) %v is now %v.", owner, repo, number, issue.GetHTMLURL(), issue.GetTitle(), issue.GetState())
+}
+
+// createComment comments on the issue or pull request as the user and links to
+// the new comment.
+func (p *Plugin) createComment(userId, reference, body string) string {
+	owner, repo, number, err := parseIssueReference(reference)
+	if err != nil {
+		return err.Error()
+	}
+
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to comment", err)
+	}
+
+	comment, _, err := githubConnect(token).Issues.CreateComment(context.Background(), owner, repo, number, &github.IssueComment{Body: github.String(body)})
+	if err != nil {
+		return p.describeIssueError(userId, fmt.Sprintf("Unable to comment on **%v/%v#%v**", owner, repo, number), err)
+	}
+
+	return fmt.Sprintf("Commented on [**%v/%v#%v**](%v).", owner, repo, number, comment.GetHTMLURL())
+}
+
+// assign adds the GitHub users as assignees of the issue or pull request as
+// the user. GitHub silently skips anyone who can't be assigned, so they are
+// listed separately.
+func (p *Plugin) assign(userId, reference string, logins []string) string {
+	owner, repo, number, err := parseIssueReference(reference)
+	if err != nil {
+		return err.Error()
+	}
+
+	var assignees []string
+	for _, login := range logins {
+		if login = strings.TrimPrefix(strings.TrimSpace(login), "@"); login != "" {
+			assignees = append(assignees, login)
+		}
+	}
+	if len(assignees) == 0 {
+		return "Wrong number of parameters."
+	}
+
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to assign", err)
+	}
+
+	issue, _, err := githubConnect(token).Issues.AddAssignees(context.Background(), owner, repo, number, assignees)
+	if err != nil {
+		return p.describeIssueError(userId, fmt.Sprintf("Unable to assign **%v/%v#%v**", owner, repo, number), err)
+	}
+
+	assigned := githubUserListToUsernames(issue.Assignees)
+	var skipped []string
+	for _, login := range assignees {
+		found := false
+		for _, assignee := range assigned {
+			if strings.EqualFold(login, assignee) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			skipped = append(skipped, login)
+		}
+	}
+
+	text := fmt.Sprintf("[**%v/%v#%v**](%v) is assigned to %v.", owner, repo, number, issue.GetHTMLURL(), strings.Join(assigned, ", "))
+	if len(assigned) == 0 {
+		text = fmt.Sprintf("[**%v/%v#%v**](%v) has no assignees.", owner, repo, number, issue.GetHTMLURL())
+	}
+	if len(skipped) > 0 {
+		text += fmt.Sprintf(" Could not assign %v; they may not have access to the repository.", strings.Join(skipped, ", "))
+	}
+	return text
+}
+
+// refreshPullRequest posts an up to date card for the pull request to the
+// channel. The pull request is looked up with the user's token so that nobody
+// can post cards for pull requests they can't see. It returns why the card
+// couldn't be posted, if it wasn't.
+func (p *Plugin) refreshPullRequest(userId, channelId, reference string) string {
+	owner, repo, number, err := parseIssueReference(reference)
+	if err != nil {
+		return err.Error()
+	}
+
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to refresh the pull request", err)
+	}
+
+	pullRequest, _, err := githubConnect(token).PullRequests.Get(context.Background(), owner, repo, number)
+	if err != nil {
+		return p.describeIssueError(userId, fmt.Sprintf("Unable to find the pull request **%v/%v#%v**", owner, repo, number), err)
+	}
+
+	gob.Register([]map[string]string{})
+
+	post := p.postFromPullRequest(owner, repo, pullRequest, true)
+	post.ChannelId = channelId
+	if _, err := p.api.CreatePost(post); err != nil {
+		return "Unable to post the pull request: " + err.Error()
+	}
+	return ""
+}
+
+// DIFF_FILES_LIMIT caps the number of files /github diff lists.
+const DIFF_FILES_LIMIT = 30
+
+// describeDiff summarizes the files the pull request changes, with the lines
+// added and deleted in each, using the user's token. It returns false if the
+// summary couldn't be made, in which case the text explains why.
+func (p *Plugin) describeDiff(userId, reference string) (string, bool) {
+	owner, repo, number, err := parseIssueReference(reference)
+	if err != nil {
+		return err.Error(), false
+	}
+
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to summarize the diff", err), false
+	}
+	githubClient := githubConnect(token)
+	ctx := context.Background()
+	prefix := fmt.Sprintf("Unable to summarize the diff of **%v/%v#%v**", owner, repo, number)
+
+	// The totals come from the pull request, since only some of its files
+	// are listed.
+	pullRequest, _, err := githubClient.PullRequests.Get(ctx, owner, repo, number)
+	if err != nil {
+		return p.describeIssueError(userId, prefix, err), false
+	}
+
+	var files []*github.CommitFile
+	_, err = paginate(&github.ListOptions{PerPage: DIFF_FILES_LIMIT}, DIFF_FILES_LIMIT, func(opt *github.ListOptions) (int, *github.Response, error) {
+		page, resp, err := githubClient.PullRequests.ListFiles(ctx, owner, repo, number, opt)
+		files = append(files, page...)
+		return len(page), resp, err
+	})
+	if err != nil {
+		return p.describeIssueError(userId, prefix, err), false
+	}
+	if len(files) > DIFF_FILES_LIMIT {
+		files = files[:DIFF_FILES_LIMIT]
+	}
+
+	lines := []string{
+		fmt.Sprintf("[%v/%v#%v %v](%v) changes %v files, +%v -%v:", owner, repo, number, pullRequest.GetTitle(), pullRequest.GetHTMLURL(), pullRequest.GetChangedFiles(), pullRequest.GetAdditions(), pullRequest.GetDeletions()),
+	}
+	if len(files) == 0 {
+		return lines[0], true
+	}
+
+	lines = append(lines, "", "| File | + | - |", "|---|---|---|")
+	for _, file := range files {
+		name := "`" + file.GetFilename() + "`"
+		if status := file.GetStatus(); status != "" && status != "modified" {
+			name += " (" + status + ")"
+		}
+		lines = append(lines, fmt.Sprintf("| %v | %v | %v |", name, file.GetAdditions(), file.GetDeletions()))
+	}
+	if more := pullRequest.GetChangedFiles() - len(files); more > 0 {
+		lines = append(lines, "", fmt.Sprintf("...and %v more files.", more))
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// setStarred stars or unstars the repository as the user.
+func (p *Plugin) setStarred(userId, repository string, star bool) string {
+	owner, repo, err := parseRepository(repository)
+	if err != nil {
+		return err.Error()
+	}
+
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to star the repository", err)
+	}
+	githubClient := githubConnect(token)
+	ctx := context.Background()
+
+	starred, _, err := githubClient.Activity.IsStarred(ctx, owner, repo)
+	if err != nil {
+		return p.describeIssueError(userId, fmt.Sprintf("Unable to find **%v/%v**", owner, repo), err)
+	}
+
+	switch {
+	case star && starred:
+		return fmt.Sprintf("You have already starred **%v/%v**.", owner, repo)
+	case !star && !starred:
+		return fmt.Sprintf("You haven't starred **%v/%v**.", owner, repo)
+	case star:
+		_, err = githubClient.Activity.Star(ctx, owner, repo)
+	default:
+		_, err = githubClient.Activity.Unstar(ctx, owner, repo)
+	}
+	if err != nil {
+		return p.describeIssueError(userId, fmt.Sprintf("Unable to update your star on **%v/%v**", owner, repo), err)
+	}
+
+	if star {
+		return fmt.Sprintf("Starred **%v/%v**.", owner, repo)
+	}
+	return fmt.Sprintf("Unstarred **%v/%v**.", owner, repo)
+}
+
+// describeRateLimits shows the core and search rate limits of the user's
+// token, or of the server's if the user hasn't registered one.
+func (p *Plugin) describeRateLimits(userId string) string {
+	githubClient := p.githubClient
+	whose := "the server's GitHub connection"
+	token, err := p.getUserToken(userId)
+	switch {
+	case err == nil:
+		githubClient = githubConnect(token)
+		whose = "your GitHub token"
+	case err != errNoUserToken:
+		return describeUserTokenError("Unable to get the rate limits", err)
+	}
+
+	limits, _, err := githubClient.RateLimits(context.Background())
+	if err != nil {
+		return "Unable to get the rate limits: " + err.Error()
+	}
+
+	lines := []string{
+		fmt.Sprintf("Rate limits of %v:", whose),
+		"",
+		"| Bucket | Remaining | Resets at |",
+		"|---|---|---|",
+	}
+	for _, bucket := range []struct {
+		name string
+		rate *github.Rate
+	}{{"Core", limits.GetCore()}, {"Search", limits.GetSearch()}} {
+		if bucket.rate == nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("| %v | %v of %v | %v |", bucket.name, bucket.rate.Remaining, bucket.rate.Limit, bucket.rate.Reset.Format(time.RFC1123)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// label adds the label to the issue or pull request, or removes it, with the
+// user's token and describes the labels it ends up with. Labels are only
+// added if they already exist, since GitHub would otherwise create them.
+func (p *Plugin) label(userId, reference string, add bool, name string) string {
+	owner, repo, number, err := parseIssueReference(reference)
+	if err != nil {
+		return err.Error()
+	}
+
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return describeUserTokenError("Unable to label", err)
+	}
+
+	githubClient := githubConnect(token)
+	ctx := context.Background()
+	prefix := fmt.Sprintf("Unable to update the labels of **%v/%v#%v**", owner, repo, number)
+
+	if add {
+		if _, _, err := githubClient.Issues.GetLabel(ctx, owner, repo, name); err != nil {
+			if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+				return fmt.Sprintf("**%v/%v** has no label named **%v**, or you don't have access to the repository.", owner, repo, name)
+			}
+			return p.describeIssueError(userId, prefix, err)
+		}
+		if _, _, err := githubClient.Issues.AddLabelsToIssue(ctx, owner, repo, number, []string{name}); err != nil {
+			return p.describeIssueError(userId, prefix, err)
+		}
+	} else if _, err := githubClient.Issues.RemoveLabelForIssue(ctx, owner, repo, number, name); err != nil {
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+			return fmt.Sprintf("**%v/%v#%v** is not labeled **%v**, or you don't have access to the repository.", owner, repo, number, name)
+		}
+		return p.describeIssueError(userId, prefix, err)
+	}
+
+	labels, err := listIssueLabels(ctx, githubClient, owner, repo, number)
+	if err != nil {
+		return p.describeIssueError(userId, prefix, err)
+	}
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.GetName())
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("**%v/%v#%v** has no labels.", owner, repo, number)
+	}
+	return fmt.Sprintf("**%v/%v#%v** is labeled %v.", owner, repo, number, strings.Join(names, ", "))
+}
+
+// describeIssueError explains why acting on an issue as the user failed.
+func (p *Plugin) describeIssueError(userId, prefix string, err error) string {
+	if p.handleGitHubAuthError(userId, err) {
+		return "GitHub rejected your token. Use `/github register <token>` to reconnect your account."
+	}
+	if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil {
+		switch errResp.Response.StatusCode {
+		case http.StatusForbidden, http.StatusNotFound:
+			return prefix + ". Check that it exists and that you have access to the repository."
+		}
+	}
+	return prefix + ": " + err.Error()
+}
+
+// commandResponse builds a command response shown with the configured bot
+// name and icon.
+func (p *Plugin) commandResponse(responseType, text string) *model.CommandResponse {
+	config := p.config()
+	return &model.CommandResponse{
+		ResponseType: responseType,
+		Text:         text,
+		Username:     config.GetBotDisplayName(),
+		IconURL:      config.GetBotIconURL(),
+		Type:         model.POST_DEFAULT,
+	}
+}
+
+func (p *Plugin) ephemeralResponse(text string) *model.CommandResponse {
+	return p.commandResponse(model.COMMAND_RESPONSE_TYPE_EPHEMERAL, text)
+}
+
+// subscriptionResponseType returns whether subscription changes are announced
+// to the whole channel or only shown to the user who made them.
+func subscriptionResponseType(config *Configuration) string {
+	if config.BroadcastSubscriptions {
+		return model.COMMAND_RESPONSE_TYPE_IN_CHANNEL
+	}
+	return model.COMMAND_RESPONSE_TYPE_EPHEMERAL
+}
+
+// isSystemAdmin reports whether the user has the system admin role.
+func (p *Plugin) isSystemAdmin(userId string) bool {
+	user, err := p.api.GetUser(userId)
+	if err != nil {
+		return false
+	}
+	return model.IsInRole(user.Roles, model.SYSTEM_ADMIN_ROLE_ID)
+}
+
+// isChannelAdmin reports whether the user is an admin of the channel. System
+// admins are admins of every channel.
+func (p *Plugin) isChannelAdmin(userId, channelId string) bool {
+	if p.isSystemAdmin(userId) {
+		return true
+	}
+
+	member, err := p.api.GetChannelMember(channelId, userId)
+	if err != nil {
+		return false
+	}
+	return model.IsInRole(member.Roles, model.CHANNEL_ADMIN_ROLE_ID)
+}
+
+// testConnection checks that the configured GitHub token works and describes
+// the account it authenticates as and its remaining rate limit.
+func (p *Plugin) testConnection() string {
+	// Installations aren't users, so they can only be checked by their rate
+	// limit.
+	if config := p.config(); config.IsGitHubAppConfigured() {
+		limits, _, err := p.githubClient.RateLimits(context.Background())
+		if err != nil {
+			return "Unable to connect to GitHub as the configured app: " + err.Error()
+		}
+		// GetCore is nil safe, but GitHub may leave the core limit out.
+		rate := limits.GetCore()
+		if rate == nil {
+			return fmt.Sprintf("Connected to GitHub as installation %v of app %v, but GitHub didn't report its rate limit.",
+				config.GithubAppInstallationID, config.GithubAppID)
+		}
+		return fmt.Sprintf("Connected to GitHub as installation %v of app %v.\nRate limit: %v of %v requests remaining, resets at %v.",
+			config.GithubAppInstallationID, config.GithubAppID, rate.Remaining, rate.Limit, rate.Reset.Format(time.RFC1123))
+	}
+
+	me, resp, err := p.githubClient.Users.Get(context.Background(), "")
+	if err != nil {
+		return "Unable to connect to GitHub with the configured token: " + err.Error()
+	}
+
+	return fmt.Sprintf("Connected to GitHub as **%v**.\nRate limit: %v of %v requests remaining, resets at %v.",
+		me.GetLogin(), resp.Rate.Remaining, resp.Rate.Limit, resp.Rate.Reset.Format(time.RFC1123))
+}
@@ -1,20 +1,166 @@
 package main
 
-import "fmt"
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// States that attachments are colored for.
+const (
+	STATE_OPEN   = "open"
+	STATE_CLOSED = "closed"
+	STATE_MERGED = "merged"
+	STATE_DRAFT  = "draft"
+)
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// defaultStateColors are the default palette of state colors, used for the
+// states AttachmentColors doesn't set.
+var defaultStateColors = map[string]string{
+	STATE_OPEN:   "#dbab09",
+	STATE_CLOSED: "#cb2431",
+	STATE_MERGED: "#2cbe4e",
+	STATE_DRAFT:  "#6f42c1",
+}
+
+const (
+	DEFAULT_MAX_LIST_ITEMS      = 10
+	DEFAULT_STALE_TODO_DAYS     = 7
+	DEFAULT_WEBHOOK_MAX_BODY_MB = 5
+	DEFAULT_BOT_DISPLAY_NAME    = "github"
+	DEFAULT_BOT_ICON_URL        = "https://assets-cdn.github.com/images/modules/logos_page/GitHub-Mark.png"
+)
 
 type Configuration struct {
-	GithubToken   string
+	GithubToken string
+
+	// GithubAppID, GithubAppInstallationID and GithubAppPrivateKeyPath make
+	// the plugin authenticate as an installation of a GitHub App instead of
+	// with GithubToken.
+	GithubAppID             string
+	GithubAppInstallationID string
+	GithubAppPrivateKeyPath string
+
+	// GithubOrg is a comma separated list of the organizations scanned by
+	// /github todo.
 	GithubOrg     string
 	WebhookSecret string
 	Username      string
+
+	// OrgWebhookSecrets holds webhook secrets for deliveries from particular
+	// organizations, as comma separated org:secret pairs. Deliveries from any
+	// other organization use WebhookSecret.
+	OrgWebhookSecrets string
+
+	// BotLogins is a comma separated list of GitHub logins treated as bots by
+	// subscriptions that ignore bots, on top of GitHub's own bot accounts.
+	BotLogins string
+
+	// SkipDraftPullRequests posts draft pull requests when they are marked
+	// ready for review rather than when they are opened.
+	SkipDraftPullRequests bool
+
+	// AsyncReviewers makes pull request posts without waiting for their
+	// reviewers to be looked up, and adds the reviewers afterwards.
+	AsyncReviewers bool
+
+	// MigrateRenamedRepositories moves subscriptions to a repository's new
+	// name when Github reports that it was renamed.
+	MigrateRenamedRepositories bool
+
+	// WebhookSecretPrevious is also accepted while the webhook secret is being
+	// rotated. It should be cleared once Github uses the new secret.
+	WebhookSecretPrevious string
+
+	// WebhookPathToken is the path segment the webhook is served under, as
+	// /webhook/<token>. When empty, the token generated for the install is
+	// used instead.
+	WebhookPathToken string
+
+	// AcceptLegacyWebhookPath keeps serving LEGACY_WEBHOOK_PATH for hooks
+	// created before the webhook got a path token.
+	AcceptLegacyWebhookPath bool
+
+	// GithubOrgIsUser treats GithubOrg as a user account rather than an
+	// organization, for repositories that are not owned by an organization.
+	GithubOrgIsUser bool
+
+	// RestrictSubscriptions limits subscribing and unsubscribing channels to
+	// channel and system admins.
+	RestrictSubscriptions bool
+
+	// BroadcastSubscriptions posts subscription changes to the whole channel
+	// instead of only to the user who made them.
+	BroadcastSubscriptions bool
+
+	// DisabledEvents is a comma separated list of events that are never posted,
+	// whatever the subscriptions ask for.
+	DisabledEvents string
+
+	// BotDisplayName and BotIconURL are how the plugin's posts and command
+	// responses are displayed.
+	BotDisplayName string
+	BotIconURL     string
+
+	// MaxListItems caps how many entries of a list, such as labels or
+	// reviewers, are rendered in a post before the rest are summarized.
+	MaxListItems string
+
+	// ProxyURL and CACertPath route GitHub requests through an HTTP proxy and
+	// trust an extra certificate authority, such as the one that signed a
+	// GitHub Enterprise server's certificate.
+	ProxyURL   string
+	CACertPath string
+
+	// AttachmentColors overrides the colors of attachments for pull request
+	// states, as comma separated state:#rrggbb pairs such as open:#ffcc00.
+	AttachmentColors string
+
+	// SiteURL is the Mattermost server's public URL. Buttons on pull request
+	// posts need it to call back into the plugin, so there are none without
+	// it.
+	SiteURL string
+
+	// StaleTodoDays is how many days old a pull request must be to be listed
+	// by /github todo stale when no age is given.
+	StaleTodoDays string
+
+	// TodoBaseBranches is a comma separated list of base branch patterns,
+	// such as main,release/*. When set, /github todo only lists pull requests
+	// into matching branches unless the user gives --base.
+	TodoBaseBranches string
+
+	// WebhookMaxBodyMB is the size in megabytes above which webhook
+	// deliveries are rejected, so that the public endpoint can't be made to
+	// read an unbounded body.
+	WebhookMaxBodyMB string
 }
 
 func (c *Configuration) IsValid() error {
-	if c.GithubToken == "" {
+	if c.IsGitHubAppConfigured() {
+		if _, err := strconv.ParseInt(c.GithubAppID, 10, 64); err != nil {
+			return fmt.Errorf("The github app ID must be a number")
+		}
+		if _, err := strconv.ParseInt(c.GithubAppInstallationID, 10, 64); err != nil {
+			return fmt.Errorf("The github app installation ID must be a number")
+		}
+	} else if c.GithubAppID != "" || c.GithubAppInstallationID != "" || c.GithubAppPrivateKeyPath != "" {
+		return fmt.Errorf("A github app needs an app ID, installation ID and private key path")
+	} else if c.GithubToken == "" {
 		return fmt.Errorf("Must have a github token")
 	}
 
-	if c.GithubOrg == "" {
+	if len(c.GetOrgs()) == 0 {
 		return fmt.Errorf("Must have a github Org")
 	}
 
@@ -22,5 +168,246 @@ func (c *Configuration) IsValid() error {
 		return fmt.Errorf("Need a username to make posts as.")
 	}
 
+	if strings.Contains(c.WebhookPathToken, "/") {
+		return fmt.Errorf("The webhook path token must not contain a /")
+	}
+
+	if c.MaxListItems != "" {
+		if max, err := strconv.Atoi(c.MaxListItems); err != nil || max < 1 {
+			return fmt.Errorf("The maximum list items must be a positive number")
+		}
+	}
+
+	if c.ProxyURL != "" {
+		if proxyURL, err := url.Parse(c.ProxyURL); err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return fmt.Errorf("The proxy URL must be an absolute URL")
+		}
+	}
+
+	for _, pair := range strings.Split(c.OrgWebhookSecrets, ",") {
+		if pair = strings.TrimSpace(pair); pair != "" && !strings.Contains(pair, ":") {
+			return fmt.Errorf("Organization webhook secrets must be given as org:secret")
+		}
+	}
+
+	if _, err := parseStateColors(c.AttachmentColors); err != nil {
+		return fmt.Errorf("Invalid attachment colors: %v", err.Error())
+	}
+
+	if c.SiteURL != "" {
+		if siteURL, err := url.Parse(c.SiteURL); err != nil || siteURL.Scheme == "" || siteURL.Host == "" {
+			return fmt.Errorf("The site URL must be an absolute URL")
+		}
+	}
+
+	if c.StaleTodoDays != "" {
+		if days, err := strconv.Atoi(c.StaleTodoDays); err != nil || days < 1 {
+			return fmt.Errorf("The stale todo days must be a positive number")
+		}
+	}
+
+	if c.WebhookMaxBodyMB != "" {
+		if size, err := strconv.Atoi(c.WebhookMaxBodyMB); err != nil || size < 1 {
+			return fmt.Errorf("The webhook maximum body size must be a positive number")
+		}
+	}
+
+	if _, err := parseBranchPatterns(c.TodoBaseBranches); err != nil {
+		return fmt.Errorf("Invalid todo base branches: %v", err.Error())
+	}
+
+	if _, err := parseEvents(c.DisabledEvents); err != nil {
+		return fmt.Errorf("Invalid disabled events: %v", err.Error())
+	}
+
 	return nil
 }
+
+// IsGitHubAppConfigured reports whether the plugin should authenticate as a
+// GitHub App installation.
+func (c *Configuration) IsGitHubAppConfigured() bool {
+	return c.GithubAppID != "" && c.GithubAppInstallationID != "" && c.GithubAppPrivateKeyPath != ""
+}
+
+// IsEventDisabled reports whether the event has been turned off server wide.
+func (c *Configuration) IsEventDisabled(event string) bool {
+	events, _ := parseEvents(c.DisabledEvents)
+	for _, disabled := range events {
+		if disabled == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Configuration) GetBotDisplayName() string {
+	if c.BotDisplayName == "" {
+		return DEFAULT_BOT_DISPLAY_NAME
+	}
+	return c.BotDisplayName
+}
+
+func (c *Configuration) GetBotIconURL() string {
+	if c.BotIconURL == "" {
+		return DEFAULT_BOT_ICON_URL
+	}
+	return c.BotIconURL
+}
+
+func (c *Configuration) GetMaxListItems() int {
+	max, err := strconv.Atoi(c.MaxListItems)
+	if err != nil || max < 1 {
+		return DEFAULT_MAX_LIST_ITEMS
+	}
+	return max
+}
+
+func (c *Configuration) GetStaleTodoDays() int {
+	days, err := strconv.Atoi(c.StaleTodoDays)
+	if err != nil || days < 1 {
+		return DEFAULT_STALE_TODO_DAYS
+	}
+	return days
+}
+
+// GetWebhookMaxBodySize returns the largest webhook body accepted, in bytes.
+func (c *Configuration) GetWebhookMaxBodySize() int64 {
+	size, err := strconv.Atoi(c.WebhookMaxBodyMB)
+	if err != nil || size < 1 {
+		size = DEFAULT_WEBHOOK_MAX_BODY_MB
+	}
+	return int64(size) * 1024 * 1024
+}
+
+// GetTodoBaseBranches returns the base branch patterns /github todo is
+// limited to by default, or nil for every branch.
+func (c *Configuration) GetTodoBaseBranches() []string {
+	bases, _ := parseBranchPatterns(c.TodoBaseBranches)
+	return bases
+}
+
+// IsBot reports whether the GitHub user is a bot account or one of the
+// configured bot logins.
+func (c *Configuration) IsBot(user *github.User) bool {
+	if user.GetType() == "Bot" {
+		return true
+	}
+	for _, login := range strings.Split(c.BotLogins, ",") {
+		if login = strings.TrimSpace(login); login != "" && strings.EqualFold(login, user.GetLogin()) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetOrgs returns the configured organizations.
+func (c *Configuration) GetOrgs() []string {
+	var orgs []string
+	for _, org := range strings.Split(c.GithubOrg, ",") {
+		if org = strings.TrimSpace(org); org != "" {
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs
+}
+
+// FindOrg returns the configured organization matching name, ignoring case.
+func (c *Configuration) FindOrg(name string) (string, bool) {
+	for _, org := range c.GetOrgs() {
+		if strings.EqualFold(org, name) {
+			return org, true
+		}
+	}
+	return "", false
+}
+
+// GetWebhookSecret returns the webhook secret for deliveries about the
+// owner's repositories.
+func (c *Configuration) GetWebhookSecret(owner string) string {
+	if orgSecret, ok := c.getOrgWebhookSecrets()[strings.ToLower(owner)]; ok {
+		return orgSecret
+	}
+	return c.WebhookSecret
+}
+
+// webhookSecrets returns the secrets deliveries about the owner's
+// repositories are accepted with: the owner's own, or else the global one and
+// the previous global one during a rotation.
+func (c *Configuration) webhookSecrets(owner string) []string {
+	if orgSecret, ok := c.getOrgWebhookSecrets()[strings.ToLower(owner)]; ok {
+		return []string{orgSecret}
+	}
+	secrets := []string{c.WebhookSecret}
+	if c.WebhookSecretPrevious != "" {
+		secrets = append(secrets, c.WebhookSecretPrevious)
+	}
+	return secrets
+}
+
+// IsWebhookSecret reports whether secret, given in the webhook URL by hooks
+// set up before deliveries were signed, is one of the owner's secrets.
+func (c *Configuration) IsWebhookSecret(owner, secret string) bool {
+	for _, expected := range c.webhookSecrets(owner) {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWebhookSignature reports whether signature, the X-Hub-Signature header
+// of a delivery, signs the body with one of the owner's secrets.
+func (c *Configuration) IsWebhookSignature(owner, signature string, body []byte) bool {
+	for _, secret := range c.webhookSecrets(owner) {
+		mac := hmac.New(sha1.New, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal([]byte(signature), []byte("sha1="+hex.EncodeToString(mac.Sum(nil)))) {
+			return true
+		}
+	}
+	return false
+}
+
+// getOrgWebhookSecrets parses OrgWebhookSecrets, keyed by lower case org.
+func (c *Configuration) getOrgWebhookSecrets() map[string]string {
+	secrets := map[string]string{}
+	for _, pair := range strings.Split(c.OrgWebhookSecrets, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || parts[1] == "" {
+			continue
+		}
+		secrets[strings.ToLower(strings.TrimSpace(parts[0]))] = parts[1]
+	}
+	return secrets
+}
+
+// colorForState returns the attachment color for a pull request state,
+// preferring the configured one to the default palette's.
+func (c *Configuration) colorForState(state string) string {
+	colors, _ := parseStateColors(c.AttachmentColors)
+	if color, ok := colors[state]; ok {
+		return color
+	}
+	return defaultStateColors[state]
+}
+
+// parseStateColors parses comma separated state:#rrggbb pairs.
+func parseStateColors(value string) (map[string]string, error) {
+	colors := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		state := strings.ToLower(strings.TrimSpace(parts[0]))
+		if _, ok := defaultStateColors[state]; !ok || len(parts) != 2 {
+			return nil, fmt.Errorf("%v is not a known state and a color, such as open:#dbab09", pair)
+		}
+		color := strings.TrimSpace(parts[1])
+		if !hexColorPattern.MatchString(color) {
+			return nil, fmt.Errorf("%v is not a color such as #dbab09", color)
+		}
+		colors[state] = color
+	}
+	return colors, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEBUG_REPOSITORY_KEY_PREFIX = "debug_"
+
+	// DEBUG_LOGGING_DURATION is how long a repository stays flagged for
+	// verbose logging, so that a forgotten flag doesn't fill the log.
+	DEBUG_LOGGING_DURATION = time.Hour
+)
+
+// webhookEvents maps GitHub's webhook types to the subscription events they
+// are posted for.
+var webhookEvents = map[string]string{
+	"pull_request":        EVENT_PULLS,
+	"pull_request_review": EVENT_REVIEWS,
+	"discussion":          EVENT_DISCUSSIONS,
+	"create":              EVENT_REFS,
+	"delete":              EVENT_REFS,
+	"issues":              EVENT_ISSUES,
+}
+
+// setRepositoryDebug turns verbose webhook logging for the repository on,
+// until DEBUG_LOGGING_DURATION from now, or off. The KV store has no expiry
+// of its own, so the deadline is stored as the value.
+func (p *Plugin) setRepositoryDebug(repository string, on bool) error {
+	key := DEBUG_REPOSITORY_KEY_PREFIX + normalizeRepository(repository)
+	if !on {
+		if err := p.api.KeyValueStore().Delete(key); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	until := time.Now().Add(DEBUG_LOGGING_DURATION).Unix()
+	if err := p.api.KeyValueStore().Set(key, []byte(strconv.FormatInt(until, 10))); err != nil {
+		return err
+	}
+	return nil
+}
+
+// isRepositoryDebugged reports whether verbose webhook logging is on for the
+// repository, clearing the flag once it has expired.
+func (p *Plugin) isRepositoryDebugged(repository string) bool {
+	key := DEBUG_REPOSITORY_KEY_PREFIX + normalizeRepository(repository)
+	value, err := p.api.KeyValueStore().Get(key)
+	if err != nil || len(value) == 0 {
+		return false
+	}
+
+	until, parseErr := strconv.ParseInt(string(value), 10, 64)
+	if parseErr != nil || time.Now().Unix() > until {
+		p.api.KeyValueStore().Delete(key)
+		return false
+	}
+	return true
+}
+
+// logWebhookDebug logs the action of a delivery and the channels it resolves
+// to, if its repository is flagged for verbose logging.
+func (p *Plugin) logWebhookDebug(webhookType, deliveryID string, body []byte) {
+	var payload struct {
+		Action     string `json:"action"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Repository.FullName == "" {
+		return
+	}
+	repository := payload.Repository.FullName
+	if !p.isRepositoryDebugged(repository) {
+		return
+	}
+
+	fmt.Printf("Debug: %v webhook %v for %v, action %q\n", webhookType, deliveryID, repository, payload.Action)
+
+	event, ok := webhookEvents[webhookType]
+	if !ok {
+		fmt.Printf("Debug: %v webhooks are not posted to subscriptions\n", webhookType)
+		return
+	}
+	if p.config().IsEventDisabled(event) {
+		fmt.Printf("Debug: the %v event is disabled\n", event)
+		return
+	}
+
+	subscriptions, err := NewSubscriptionsForRepositoryFromKVStore(p.api.KeyValueStore(), repository)
+	if err != nil {
+		fmt.Println("Debug: unable to load subscriptions: " + err.Error())
+		return
+	}
+	var channels []string
+	for _, subscription := range subscriptions.GetSubscriptionsForEvent(repository, event) {
+		channel := subscription.ChannelId
+		if !p.isInSubscriptionTeams(subscription) {
+			channel += " (outside the subscription's teams)"
+		}
+		channels = append(channels, channel)
+	}
+	fmt.Printf("Debug: %v resolves to channels [%v]\n", event, strings.Join(channels, ", "))
+}
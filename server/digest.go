@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// DIGEST_CHANNELS_KEY holds when each channel with collected entries is
+	// due. The entries themselves are kept under DIGEST_KEY_PREFIX and the
+	// channel id, so that a channel's digest can be changed on its own.
+	DIGEST_CHANNELS_KEY = "digest_channels"
+	DIGEST_KEY_PREFIX   = "digest_"
+
+	// Modes a subscription can collect its posts in instead of posting them
+	// as they happen.
+	DIGEST_HOURLY = "hourly"
+	DIGEST_DAILY  = "daily"
+
+	// DIGEST_CHECK_INTERVAL is how often digests are checked for being due.
+	DIGEST_CHECK_INTERVAL = time.Minute
+
+	// DIGEST_MAX_ENTRIES caps how many entries a digest post lists.
+	DIGEST_MAX_ENTRIES = 50
+
+	// DIGEST_CLAIM_SETTLE is how long a server waits after claiming digests
+	// before checking that its claims held. DIGEST_CLAIM_TIMEOUT is how long
+	// a claim keeps other servers from posting a digest, in case the server
+	// that made it stopped before posting.
+	DIGEST_CLAIM_SETTLE  = time.Second
+	DIGEST_CLAIM_TIMEOUT = 10 * time.Minute
+)
+
+// channelDigest holds the entries collected for a channel until its digest is
+// posted at Due. Claim is set by the server about to post it, at ClaimedAt.
+type channelDigest struct {
+	Due       time.Time
+	Entries   []string
+	Claim     string `json:",omitempty"`
+	ClaimedAt time.Time
+}
+
+func parseDigest(value string) (string, error) {
+	switch digest := strings.ToLower(strings.TrimSpace(value)); digest {
+	case "", DIGEST_HOURLY, DIGEST_DAILY:
+		return digest, nil
+	default:
+		return "", fmt.Errorf("unknown digest %v, use %v or %v", value, DIGEST_HOURLY, DIGEST_DAILY)
+	}
+}
+
+// nextDigestDue returns when a digest started now is posted: at the top of
+// the next hour, or at the next midnight UTC.
+func nextDigestDue(digest string, now time.Time) time.Time {
+	interval := time.Hour
+	if digest == DIGEST_DAILY {
+		interval = 24 * time.Hour
+	}
+	return now.UTC().Truncate(interval).Add(interval)
+}
+
+func (p *Plugin) loadDigestChannels() (map[string]time.Time, error) {
+	channels := map[string]time.Time{}
+	value, err := p.api.KeyValueStore().Get(DIGEST_CHANNELS_KEY)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > 0 {
+		if err := json.Unmarshal(value, &channels); err != nil {
+			return nil, err
+		}
+	}
+	return channels, nil
+}
+
+func (p *Plugin) storeDigestChannels(channels map[string]time.Time) error {
+	b, err := json.Marshal(channels)
+	if err != nil {
+		return err
+	}
+	if appErr := p.api.KeyValueStore().Set(DIGEST_CHANNELS_KEY, b); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// loadChannelDigest returns the channel's digest, or nil if nothing was
+// collected for it.
+func (p *Plugin) loadChannelDigest(channelId string) (*channelDigest, error) {
+	value, err := p.api.KeyValueStore().Get(DIGEST_KEY_PREFIX + channelId)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+	var channel channelDigest
+	if err := json.Unmarshal(value, &channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (p *Plugin) storeChannelDigest(channelId string, channel *channelDigest) error {
+	b, err := json.Marshal(channel)
+	if err != nil {
+		return err
+	}
+	if appErr := p.api.KeyValueStore().Set(DIGEST_KEY_PREFIX+channelId, b); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// addToDigest collects the entry for the channel's next digest.
+func (p *Plugin) addToDigest(channelId, digest, entry string) {
+	p.digestsLock.Lock()
+	defer p.digestsLock.Unlock()
+
+	channel, err := p.loadChannelDigest(channelId)
+	if err != nil {
+		fmt.Println("Error loading the digest: " + err.Error())
+		return
+	}
+	if channel == nil {
+		channel = &channelDigest{Due: nextDigestDue(digest, time.Now())}
+	}
+	channel.Entries = append(channel.Entries, entry)
+	if err := p.storeChannelDigest(channelId, channel); err != nil {
+		fmt.Println("Error storing the digest: " + err.Error())
+		return
+	}
+
+	// The channel is listed again with every entry, so that it is posted even
+	// if another server dropped it from the list while it was being changed.
+	channels, err := p.loadDigestChannels()
+	if err != nil {
+		fmt.Println("Error loading digests: " + err.Error())
+		return
+	}
+	channels[channelId] = channel.Due
+	if err := p.storeDigestChannels(channels); err != nil {
+		fmt.Println("Error storing digests: " + err.Error())
+	}
+}
+
+// postDueDigests posts and clears the digests that are due. The key value
+// store can't compare and swap, so with several servers each due digest is
+// first claimed, and only posted by the server whose claim is still there
+// once they all had time to write theirs. A digest is cleared only once it
+// was posted, and is tried again on the next check if posting fails.
+func (p *Plugin) postDueDigests(now time.Time) {
+	p.digestsLock.Lock()
+	defer p.digestsLock.Unlock()
+
+	channels, err := p.loadDigestChannels()
+	if err != nil {
+		fmt.Println("Error loading digests: " + err.Error())
+		return
+	}
+
+	changed := false
+	claims := map[string]string{}
+	for channelId, due := range channels {
+		if now.Before(due) {
+			continue
+		}
+		channel, err := p.loadChannelDigest(channelId)
+		if err != nil {
+			fmt.Println("Error loading the digest: " + err.Error())
+			continue
+		}
+		if channel == nil {
+			delete(channels, channelId)
+			changed = true
+			continue
+		}
+		if channel.Claim != "" && now.Sub(channel.ClaimedAt) < DIGEST_CLAIM_TIMEOUT {
+			continue
+		}
+
+		channel.Claim, channel.ClaimedAt = model.NewId(), now
+		if err := p.storeChannelDigest(channelId, channel); err != nil {
+			fmt.Println("Error claiming the digest: " + err.Error())
+			continue
+		}
+		claims[channelId] = channel.Claim
+	}
+
+	if len(claims) > 0 {
+		time.Sleep(DIGEST_CLAIM_SETTLE)
+	}
+	for channelId, claim := range claims {
+		channel, err := p.loadChannelDigest(channelId)
+		if err != nil {
+			fmt.Println("Error loading the digest: " + err.Error())
+			continue
+		}
+		if channel == nil || channel.Claim != claim {
+			continue
+		}
+
+		entries := truncateList(channel.Entries, DIGEST_MAX_ENTRIES)
+		message := fmt.Sprintf("GitHub digest, %v updates:\n* %v", len(channel.Entries), strings.Join(entries, "\n* "))
+		if _, err := p.api.CreatePost(p.newPost(channelId, message)); err != nil {
+			fmt.Println("Error posting digest: " + err.Error())
+			channel.Claim = ""
+			if err := p.storeChannelDigest(channelId, channel); err != nil {
+				fmt.Println("Error releasing the digest: " + err.Error())
+			}
+			continue
+		}
+		p.metrics.incPosts()
+
+		if err := p.api.KeyValueStore().Delete(DIGEST_KEY_PREFIX + channelId); err != nil {
+			fmt.Println("Error clearing the digest: " + err.Error())
+		}
+		delete(channels, channelId)
+		changed = true
+	}
+
+	if changed {
+		if err := p.storeDigestChannels(channels); err != nil {
+			fmt.Println("Error storing digests: " + err.Error())
+		}
+	}
+}
+
+// runDigests posts digests as they become due until ctx is cancelled.
+func (p *Plugin) runDigests(ctx context.Context) {
+	ticker := time.NewTicker(DIGEST_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.postDueDigests(now)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// HEALTH_CHECK_INTERVAL is how long the result of pinging GitHub is
+	// reused, so that frequent health checks don't add up to many requests.
+	HEALTH_CHECK_INTERVAL = time.Minute
+	HEALTH_CHECK_TIMEOUT  = 5 * time.Second
+)
+
+type HealthResponse struct {
+	Status          string `json:"status"`
+	GithubReachable bool   `json:"github_reachable"`
+}
+
+// githubHealth caches whether GitHub answered the last ping.
+type githubHealth struct {
+	lock      sync.Mutex
+	checkedAt time.Time
+	reachable bool
+}
+
+// isGitHubReachable pings GitHub, at most once per HEALTH_CHECK_INTERVAL. The
+// rate limit endpoint is used since calls to it don't count against the limit.
+func (p *Plugin) isGitHubReachable() bool {
+	p.health.lock.Lock()
+	defer p.health.lock.Unlock()
+
+	if time.Since(p.health.checkedAt) < HEALTH_CHECK_INTERVAL {
+		return p.health.reachable
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+	_, _, err := p.githubClient.RateLimits(ctx)
+	p.health.reachable = err == nil
+	p.health.checkedAt = time.Now()
+	return p.health.reachable
+}
+
+// handleHealth reports whether the plugin is configured and can reach GitHub.
+// It needs no authentication, so that load balancers and monitoring can call
+// it, and it answers 503 only when the plugin isn't configured.
+func (p *Plugin) handleHealth(w http.ResponseWriter, r *http.Request) {
+	response := HealthResponse{Status: "ok"}
+	status := http.StatusOK
+	if err := p.config().IsValid(); err != nil || p.githubClient == nil {
+		response.Status = "not_configured"
+		status = http.StatusServiceUnavailable
+	} else {
+		response.GithubReachable = p.isGitHubReachable()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
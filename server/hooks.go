@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// listWebhooks lists the webhooks of the repository or, for an owner/*
+// wildcard, those of the organization.
+func listWebhooks(ctx context.Context, githubClient *github.Client, repository string) ([]*github.Hook, error) {
+	owner, repo, err := parseRepository(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []*github.Hook
+	_, err = paginate(&github.ListOptions{}, 0, func(opt *github.ListOptions) (int, *github.Response, error) {
+		var page []*github.Hook
+		var resp *github.Response
+		var err error
+		if repo == "*" {
+			page, resp, err = githubClient.Organizations.ListHooks(ctx, owner, opt)
+		} else {
+			page, resp, err = githubClient.Repositories.ListHooks(ctx, owner, repo, opt)
+		}
+		hooks = append(hooks, page...)
+		return len(page), resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// isPluginWebhook reports whether the hook is active and delivers to this
+// plugin's webhook URL. Only the path is compared, since GitHub may reach the
+// server under another host name than the site URL.
+func (p *Plugin) isPluginWebhook(hook *github.Hook) bool {
+	if !hook.GetActive() {
+		return false
+	}
+	hookURL, _ := hook.Config["url"].(string)
+	parsed, err := url.Parse(hookURL)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(strings.TrimRight(parsed.Path, "/"), "/plugins/github"+p.webhookURLPath())
+}
+
+// checkWebhook looks for a webhook delivering the repository's events to
+// this plugin, using the user's token, since a subscription without one never
+// posts anything. It returns a warning, or an empty string if there is one.
+func (p *Plugin) checkWebhook(userId, repository string) string {
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return fmt.Sprintf("Couldn't check that **%v** has a webhook for this plugin, since your GitHub account isn't connected.", repository)
+	}
+
+	ctx := context.Background()
+	githubClient := githubConnect(token)
+	hooks, err := listWebhooks(ctx, githubClient, repository)
+	if err != nil {
+		return describeWebhookError(fmt.Sprintf("Couldn't check that **%v** has a webhook for this plugin", repository), err)
+	}
+
+	// An organization's webhook delivers the events of all its repositories.
+	// Only organization admins can see it, so it is looked for quietly.
+	if owner, repo, _ := parseRepository(repository); repo != "*" {
+		orgHooks, _ := listWebhooks(ctx, githubClient, owner+"/*")
+		hooks = append(hooks, orgHooks...)
+	}
+
+	for _, hook := range hooks {
+		if p.isPluginWebhook(hook) {
+			return ""
+		}
+	}
+	return fmt.Sprintf(":warning: Found no active webhook for this plugin on **%v**, so nothing will be posted until one is added. Ask a system admin to subscribe again with `--create-hook` to add it, or to give you the URL from `/github webhook`.", repository)
+}
+
+// describeWebhookError explains why a repository's or organization's webhooks
+// couldn't be listed or created. GitHub hides them from non-admins as if they
+// didn't exist.
+func describeWebhookError(prefix string, err error) string {
+	if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil {
+		switch errResp.Response.StatusCode {
+		case http.StatusNotFound, http.StatusForbidden:
+			return prefix + ", since that takes admin access to it and a token with the `admin:repo_hook` scope."
+		}
+	}
+	return prefix + ": " + err.Error()
+}
+
+// pluginWebhookEvents are the GitHub events the webhooks the plugin creates
+// deliver.
+var pluginWebhookEvents = []string{
+	"pull_request",
+	"pull_request_review",
+	"pull_request_review_comment",
+	"issues",
+	"issue_comment",
+	"discussion",
+	"create",
+	"delete",
+	"repository",
+}
+
+// createWebhook adds a webhook delivering the repository's events, or the
+// organization's for an owner/* wildcard, to this plugin, using the user's
+// token, unless there already is one. It describes the outcome. Only system
+// admins may use it, since the hook is given the owner's secret.
+func (p *Plugin) createWebhook(userId, repository string) string {
+	if p.config().SiteURL == "" {
+		return fmt.Sprintf("Couldn't create a webhook for **%v**, since the Site URL setting that GitHub would deliver to isn't set.", repository)
+	}
+	owner, repo, err := parseRepository(repository)
+	if err != nil {
+		return err.Error()
+	}
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return fmt.Sprintf("Couldn't create a webhook for **%v**, since your GitHub account isn't connected.", repository)
+	}
+
+	ctx := context.Background()
+	githubClient := githubConnect(token)
+	prefix := fmt.Sprintf("Couldn't create a webhook for **%v**", repository)
+	hooks, err := listWebhooks(ctx, githubClient, repository)
+	if err != nil {
+		return describeWebhookError(prefix, err)
+	}
+	for _, hook := range hooks {
+		if p.isPluginWebhook(hook) {
+			return fmt.Sprintf("**%v** already has a webhook for this plugin.", repository)
+		}
+	}
+
+	// GitHub signs the deliveries with the secret, which it never shows again.
+	hook := &github.Hook{
+		Name:   github.String("web"),
+		Active: github.Bool(true),
+		Events: pluginWebhookEvents,
+		Config: map[string]interface{}{
+			"url":          p.webhookURL(),
+			"content_type": "json",
+			"secret":       p.config().GetWebhookSecret(owner),
+		},
+	}
+	if repo == "*" {
+		_, _, err = githubClient.Organizations.CreateHook(ctx, owner, hook)
+	} else {
+		_, _, err = githubClient.Repositories.CreateHook(ctx, owner, repo, hook)
+	}
+	if err != nil {
+		return describeWebhookError(prefix, err)
+	}
+	return fmt.Sprintf("Created a webhook on **%v** delivering its %v events to this plugin.", repository, strings.Join(pluginWebhookEvents, ", "))
+}
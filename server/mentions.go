@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// MENTION_QUOTE_LENGTH caps how much of a comment is quoted in a mention DM.
+const MENTION_QUOTE_LENGTH = 500
+
+// mentionPattern matches @login mentions. A trailing slash marks a team, such
+// as @org/team, which isn't a user.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@/.])@([A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?)(/)?`)
+
+// parseMentions returns the lower case logins mentioned in the comment, each
+// once. Mentions in code aren't told apart.
+func parseMentions(body string) []string {
+	var logins []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		login := strings.ToLower(match[1])
+		if match[2] != "" || containsString(logins, login) {
+			continue
+		}
+		logins = append(logins, login)
+	}
+	return logins
+}
+
+// commentMentioned sends each connected user mentioned in the comment on the
+// repository a DM quoting it, unless they wrote it, turned mentions off or,
+// for a private repository, can't read it with their token. title describes
+// what was commented on. It reports whether anyone was sent a DM.
+func (p *Plugin) commentMentioned(repo *github.Repository, author, title, url, body string) bool {
+	quote := truncateText(strings.TrimSpace(body), MENTION_QUOTE_LENGTH)
+	message := fmt.Sprintf("**%v** mentioned you in [%v](%v):\n> %v", author, title, url, strings.Replace(quote, "\n", "\n> ", -1))
+
+	sent := false
+	for _, login := range parseMentions(body) {
+		if strings.EqualFold(login, author) {
+			continue
+		}
+		userId := p.getUserIdForGitHubLogin(login)
+		if userId == "" || !p.getMentionsEnabled(userId) {
+			continue
+		}
+		if repo.GetPrivate() && !p.canReadRepository(userId, repo) {
+			continue
+		}
+		p.sendDirectMessage(userId, message)
+		sent = true
+	}
+	return sent
+}
+
+// canReadRepository reports whether the user's GitHub token can read the
+// repository, since being mentioned in it doesn't mean they can.
+func (p *Plugin) canReadRepository(userId string, repo *github.Repository) bool {
+	token, err := p.getUserToken(userId)
+	if err != nil {
+		return false
+	}
+	_, _, err = githubConnect(token).Repositories.Get(context.Background(), repo.GetOwner().GetLogin(), repo.GetName())
+	if err != nil {
+		p.handleGitHubAuthError(userId, err)
+		return false
+	}
+	return true
+}
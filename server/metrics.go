@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// webhookMetrics counts the webhooks received, by GitHub event type, and the
+// posts created from them since the plugin was activated.
+type webhookMetrics struct {
+	lock     sync.Mutex
+	received map[string]int64
+
+	posts int64
+}
+
+func (m *webhookMetrics) incReceived(eventType string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.received == nil {
+		m.received = make(map[string]int64)
+	}
+	m.received[eventType]++
+}
+
+func (m *webhookMetrics) incPosts() {
+	atomic.AddInt64(&m.posts, 1)
+}
+
+type WebhookStats struct {
+	Received map[string]int64 `json:"received"`
+	Posts    int64            `json:"posts"`
+}
+
+func (m *webhookMetrics) stats() *WebhookStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	received := make(map[string]int64, len(m.received))
+	for eventType, count := range m.received {
+		received[eventType] = count
+	}
+
+	return &WebhookStats{
+		Received: received,
+		Posts:    atomic.LoadInt64(&m.posts),
+	}
+}
+
+func (p *Plugin) handleStats(w http.ResponseWriter, r *http.Request) {
+	if !p.requireSystemAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.metrics.stats())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// withLogging logs the method, path, status and duration of every request
+// served by next, and turns a panic into a 500 so that a bad payload can't
+// bring down the plugin. Only the path is logged since the query may carry the
+// webhook secret.
+func withLogging(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		defer func() {
+			if x := recover(); x != nil {
+				fmt.Printf("Recovered from panic serving %v %v: %v\n%s", r.Method, r.URL.Path, x, debug.Stack())
+				if !rec.wroteHeader {
+					writeJSONError(rec, http.StatusInternalServerError, "Internal server error")
+				}
+				rec.status = http.StatusInternalServerError
+			}
+			fmt.Printf("%v %v %v %v\n", r.Method, r.URL.Path, rec.status, time.Since(start))
+		}()
+
+		next(rec, r)
+	}
+}
+
+// writeJSONError answers a request with the error envelope every endpoint uses:
+// {"error": message, "status": status}.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  message,
+		"status": status,
+	})
+}
+
+// isBodyTooLarge reports whether err comes from reading past the limit of an
+// http.MaxBytesReader, which has no error value of its own to compare with.
+func isBodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
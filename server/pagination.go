@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/go-github/github"
+)
+
+// PAGE_SIZE is how many items are asked for per page of a GitHub list, which
+// is the most GitHub returns.
+const PAGE_SIZE = 100
+
+// paginate walks the pages of a GitHub list. fetch is called with the options
+// to request each page with, and returns how many items it got. Pages are
+// requested until there are no more or, when maxItems isn't zero, until at
+// least maxItems items were fetched, in which case paginate reports whether
+// pages were left. opt.PerPage defaults to PAGE_SIZE.
+func paginate(opt *github.ListOptions, maxItems int, fetch func(opt *github.ListOptions) (int, *github.Response, error)) (bool, error) {
+	if opt.PerPage == 0 {
+		opt.PerPage = PAGE_SIZE
+	}
+
+	count := 0
+	for {
+		n, resp, err := fetch(opt)
+		if err != nil {
+			return false, err
+		}
+		count += n
+		if resp == nil || resp.NextPage == 0 {
+			return false, nil
+		}
+		if maxItems > 0 && count >= maxItems {
+			return true, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// listIssueLabels returns every label on the issue or pull request.
+func listIssueLabels(ctx context.Context, githubClient *github.Client, owner, repo string, number int) ([]*github.Label, error) {
+	var labels []*github.Label
+	_, err := paginate(&github.ListOptions{}, 0, func(opt *github.ListOptions) (int, *github.Response, error) {
+		page, resp, err := githubClient.Issues.ListLabelsByIssue(ctx, owner, repo, number, opt)
+		labels = append(labels, page...)
+		return len(page), resp, err
+	})
+	return labels, err
+}
@@ -3,14 +3,14 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/subtle"
-	"encoding/gob"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/google/go-github/github"
 	"github.com/mattermost/mattermost-server/model"
@@ -19,22 +19,57 @@ import (
 )
 
 const (
-	GITHUB_TOKEN_KEY = "_githubtoken"
+	GITHUB_TOKEN_KEY    = "_githubtoken"
+	GITHUB_USERNAME_KEY = "_githubusername"
+	GITHUB_USERID_KEY   = "_githubuserid"
+	GITHUB_USERINFO_KEY = "_githubuserinfo"
 )
 
 type Plugin struct {
 	api           plugin.API
 	configuration atomic.Value
 	githubClient  *github.Client
-	userId        string
+
+	// userId is the plugin's user, which posts are made as. Use getUserId,
+	// which keeps looking the user up until it is found.
+	userId     string
+	userIdLock sync.Mutex
+
+	// ctx is cancelled when the plugin is deactivated, stopping the work
+	// started with runInBackground.
+	ctx            context.Context
+	cancel         context.CancelFunc
+	backgroundJobs sync.WaitGroup
+
+	// todosInFlight holds the users with a /github todo scan running.
+	todosInFlight     map[string]bool
+	todosInFlightLock sync.Mutex
+
+	metrics webhookMetrics
+
+	health githubHealth
+
+	// digestsLock serializes changes to the digests collected for channels.
+	digestsLock sync.Mutex
+
+	// enrichmentBreaker guards the calls that add details to posts, which
+	// can be left out if GitHub is struggling.
+	enrichmentBreaker circuitBreaker
 }
 
 func githubConnect(token string) *github.Client {
-	ctx := context.Background()
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
-	tc := oauth2.NewClient(ctx, ts)
+	tc := &http.Client{
+		Transport: &oauth2.Transport{
+			Source: ts,
+			Base: &githubTransport{
+				base:  githubBaseTransport,
+				cache: githubResponseCache,
+			},
+		},
+	}
 
 	client := github.NewClient(tc)
 
@@ -43,6 +78,7 @@ func githubConnect(token string) *github.Client {
 
 func (p *Plugin) OnActivate(api plugin.API) error {
 	p.api = api
+	p.ctx, p.cancel = context.WithCancel(context.Background())
 	if err := p.OnConfigurationChange(); err != nil {
 		return err
 	}
@@ -53,7 +89,31 @@ func (p *Plugin) OnActivate(api plugin.API) error {
 	}
 
 	// Connect to github
-	p.githubClient = githubConnect(config.GithubToken)
+	if config.IsGitHubAppConfigured() {
+		client, err := githubAppConnect(config)
+		if err != nil {
+			return fmt.Errorf("Unable to set up the github app: %v", err.Error())
+		}
+		p.githubClient = client
+	} else {
+		p.githubClient = githubConnect(config.GithubToken)
+	}
+	p.enrichmentBreaker.name = "enrichment"
+	if err := p.ensureWebhookToken(); err != nil {
+		return fmt.Errorf("Unable to set up the webhook token: %v", err.Error())
+	}
+	p.runInBackground(p.runDigests)
+
+	// A misspelled organization would otherwise only show up as failing todos.
+	// Accounts configured with GithubOrgIsUser aren't organizations, so they
+	// can't be checked this way.
+	if !config.GithubOrgIsUser {
+		for _, org := range config.GetOrgs() {
+			if _, _, err := p.githubClient.Organizations.Get(p.ctx, org); err != nil {
+				return fmt.Errorf("Unable to access the github organization %v with the configured token: %v", org, err.Error())
+			}
+		}
+	}
 
 	// Register commands
 	p.api.RegisterCommand(&model.Command{
@@ -62,82 +122,53 @@ func (p *Plugin) OnActivate(api plugin.API) error {
 		Description: "Integration with Github.",
 	})
 
-	// Get our userId
-	user, err := p.api.GetUserByUsername(config.Username)
-	if err != nil {
-		return err
+	// A missing user only stops posts from being made, so it mustn't keep the
+	// plugin from loading. It is looked up again until it is found.
+	if p.getUserId() == "" {
+		fmt.Printf("Warning: unable to find the user %v to post as. Nothing will be posted until the Username setting names an existing user.\n", config.Username)
 	}
 
-	p.userId = user.Id
-
 	return nil
 }
 
-func (p *Plugin) ExecuteCommand(args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
-	config := p.config()
-	split := strings.Split(args.Command, " ")
-	command := split[0]
-	parameters := []string{}
-	action := ""
-	if len(split) > 1 {
-		action = split[1]
-	}
-	if len(split) > 2 {
-		parameters = split[2:]
-	}
+// getUserId returns the id of the user the plugin posts as, or an empty string
+// if the configured user can't be found.
+func (p *Plugin) getUserId() string {
+	p.userIdLock.Lock()
+	defer p.userIdLock.Unlock()
 
-	if command != "/github" {
-		return nil, nil
-	}
-
-	switch action {
-	case "subscribe":
-		if len(parameters) != 1 {
-			return &model.CommandResponse{Text: "Wrong number of parameters.", ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL}, nil
+	if p.userId == "" {
+		user, err := p.api.GetUserByUsername(p.config().Username)
+		if err != nil {
+			return ""
 		}
-		subscriptions, _ := NewSubscriptionsFromKVStore(p.api.KeyValueStore())
-
-		subscriptions.Add(args.ChannelId, parameters[0])
+		p.userId = user.Id
+	}
+	return p.userId
+}
 
-		subscriptions.StoreInKVStore(p.api.KeyValueStore())
+func (p *Plugin) OnDeactivate() error {
+	if err := p.api.UnregisterCommand("", "github"); err != nil {
+		fmt.Println("Error unregistering the command: " + err.Error())
+	}
 
-		resp := &model.CommandResponse{
-			ResponseType: model.COMMAND_RESPONSE_TYPE_IN_CHANNEL,
-			Text:         "You have subscribed to the repository.",
-			Username:     "github",
-			IconURL:      "https://assets-cdn.github.com/images/modules/logos_page/GitHub-Mark.png",
-			Type:         model.POST_DEFAULT,
-		}
-		return resp, nil
-	case "register":
-		if len(parameters) != 1 {
-			return &model.CommandResponse{Text: "Wrong number of parameters.", ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL}, nil
-		}
-		p.api.KeyValueStore().Set(args.UserId+GITHUB_TOKEN_KEY, []byte(parameters[0]))
-		resp := &model.CommandResponse{
-			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
-			Text:         "Registered github token.",
-			Username:     "github",
-			IconURL:      "https://assets-cdn.github.com/images/modules/logos_page/GitHub-Mark.png",
-			Type:         model.POST_DEFAULT,
-		}
-		return resp, nil
-	case "deregister":
-		p.api.KeyValueStore().Delete(args.UserId + GITHUB_TOKEN_KEY)
-		resp := &model.CommandResponse{
-			ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
-			Text:         "Deregistered github token.",
-			Username:     "github",
-			IconURL:      "https://assets-cdn.github.com/images/modules/logos_page/GitHub-Mark.png",
-			Type:         model.POST_DEFAULT,
-		}
-		return resp, nil
-	case "todo":
-		go p.HandleTodo(args.UserId, config.GithubOrg)
-		return &model.CommandResponse{Text: "Checking GitHub for your pending PRs reviews. Get a :coffee:", ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL}, nil
+	if p.cancel != nil {
+		p.cancel()
 	}
+	p.backgroundJobs.Wait()
 
-	return nil, nil
+	return nil
+}
+
+// runInBackground runs f in a new goroutine. The context passed to f is
+// cancelled when the plugin is deactivated, and deactivation waits for f to
+// return.
+func (p *Plugin) runInBackground(f func(ctx context.Context)) {
+	p.backgroundJobs.Add(1)
+	go func() {
+		defer p.backgroundJobs.Done()
+		f(p.ctx)
+	}()
 }
 
 func (p *Plugin) config() *Configuration {
@@ -147,214 +178,760 @@ func (p *Plugin) config() *Configuration {
 func (p *Plugin) OnConfigurationChange() error {
 	var configuration Configuration
 	err := p.api.LoadPluginConfiguration(&configuration)
+	if previous, ok := p.configuration.Load().(*Configuration); ok && previous.Username != configuration.Username {
+		p.userIdLock.Lock()
+		p.userId = ""
+		p.userIdLock.Unlock()
+	}
 	p.configuration.Store(&configuration)
-	return err
+	if err != nil {
+		return err
+	}
+
+	transport, err := newBaseTransport(&configuration)
+	if err != nil {
+		fmt.Println("Error configuring the GitHub proxy or CA certificate: " + err.Error())
+		githubBaseTransport.set(http.DefaultTransport)
+		return err
+	}
+	githubBaseTransport.set(transport)
+	return nil
 }
 
 func (p *Plugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	withLogging(p.serveHTTP)(w, r)
+}
+
+func (p *Plugin) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// The health check reports an unconfigured plugin itself.
+	if r.URL.Path == "/api/v1/health" {
+		p.handleHealth(w, r)
+		return
+	}
+
 	config := p.config()
 	if err := config.IsValid(); err != nil {
-		http.Error(w, "This plugin is not configured.", http.StatusNotImplemented)
+		writeJSONError(w, http.StatusNotImplemented, "This plugin is not configured.")
 		return
 	}
 
 	switch path := r.URL.Path; path {
-	case "/webhook":
+	case p.webhookURLPath():
+		p.handleWebhook(w, r)
+	case LEGACY_WEBHOOK_PATH:
+		if !config.AcceptLegacyWebhookPath {
+			writeJSONError(w, http.StatusNotFound, "Not found")
+			return
+		}
 		p.handleWebhook(w, r)
+	case "/api/v1/stats":
+		p.handleStats(w, r)
 	case "/api/v1/pr/reviewers":
 		p.handleReviewers(w, r)
+	case "/api/v1/pr/action":
+		p.handlePullRequestAction(w, r)
+	case "/api/v1/admin/subscriptions/export":
+		p.handleSubscriptionsExport(w, r)
+	case "/api/v1/admin/subscriptions/import":
+		p.handleSubscriptionsImport(w, r)
 	default:
-		http.NotFound(w, r)
+		writeJSONError(w, http.StatusNotFound, "Not found")
 	}
 }
 
+// TODO_TIMEOUT bounds how long a single /github todo scan may run.
+const TODO_TIMEOUT = 5 * time.Minute
+
+// startTodo marks a todo scan as running for the user. It returns false if one
+// is already running.
+func (p *Plugin) startTodo(userId string) bool {
+	p.todosInFlightLock.Lock()
+	defer p.todosInFlightLock.Unlock()
+
+	if p.todosInFlight == nil {
+		p.todosInFlight = make(map[string]bool)
+	}
+	if p.todosInFlight[userId] {
+		return false
+	}
+	p.todosInFlight[userId] = true
+	return true
+}
+
+func (p *Plugin) finishTodo(userId string) {
+	p.todosInFlightLock.Lock()
+	defer p.todosInFlightLock.Unlock()
+
+	delete(p.todosInFlight, userId)
+}
+
 type PullRequestWaitingReview struct {
-	GitHubRepo        string `url:"github_repo"`
-	GitHubUserName    string `url:"github_username"`
-	PullRequestNumber int    `url:"pullrequest_number"`
-	PullRequestURL    string `url:"pullrequest_url"`
+	GitHubRepo        string    `url:"github_repo"`
+	GitHubUserName    string    `url:"github_username"`
+	PullRequestNumber int       `url:"pullrequest_number"`
+	PullRequestURL    string    `url:"pullrequest_url"`
+	CreatedAt         time.Time `url:"created_at"`
+
+	// ChangesRequested is set if the reviewer's latest review asked for
+	// changes, so the pull request is waiting on its author for now.
+	ChangesRequested bool `url:"changes_requested"`
 }
 
 type PullRequestWaitingReviews []PullRequestWaitingReview
 
-func (p *Plugin) HandleTodo(userId, gitHubOrg string) {
-	ctx := context.Background()
+// HandleTodo sends the user the pull requests of the organizations that are
+// waiting for their review. When staleDays is set, only pull requests opened
+// at least that many days ago are listed, oldest first. When bases are given,
+// only pull requests into matching base branches are listed.
+func (p *Plugin) HandleTodo(ctx context.Context, userId string, gitHubOrgs []string, staleDays int, bases []string) {
 
-	dmChannel, err := p.api.GetDirectChannel(userId, userId)
+	// Todos come from the plugin's user, in its direct channel with the user,
+	// rather than appearing in the user's conversation with themselves.
+	dmChannel, err := p.getDirectChannel(userId)
 	if err != nil {
-		fmt.Println("Error to get the DM channel")
+		fmt.Println("Error getting the direct channel: " + err.Error())
 		return
 	}
 
-	b, err := p.api.KeyValueStore().Get(userId + GITHUB_TOKEN_KEY)
-	if err != nil {
-		p.SendTodoPost("Error retrieving the GitHub User token", p.userId, dmChannel.Id)
+	gitHubUserToken, tokenErr := p.getUserToken(userId)
+	if tokenErr != nil {
+		p.SendTodoPost(describeUserTokenError("Error retrieving the GitHub User token", tokenErr), dmChannel.Id)
+		return
 	}
-	gitHubUserToken := string(b)
 
 	githubClient := githubConnect(gitHubUserToken)
 
 	// Get the user information. We need to know the username
 	me, _, err2 := githubClient.Users.Get(ctx, "")
 	if err2 != nil {
-		p.SendTodoPost("Error retrieving the GitHub User information", p.userId, dmChannel.Id)
+		if p.handleGitHubAuthError(userId, err2) {
+			return
+		}
+		p.SendTodoPost("Error retrieving the GitHub User information", dmChannel.Id)
+		return
 	}
 
-	// Get all repositories for one specific Organization and after that get an PRs for
+	// Get all repositories of the Organizations and after that get the PRs of
 	// each repository that are waiting review from the user.
-	var repos []string
-	githubRepos, _, err2 := githubClient.Repositories.ListByOrg(ctx, gitHubOrg, nil)
-	if err2 != nil {
-		p.SendTodoPost("Error retrieving the GitHub repository", p.userId, dmChannel.Id)
+	var repos []*github.Repository
+	for _, gitHubOrg := range gitHubOrgs {
+		githubRepos, err2 := listRepositories(ctx, githubClient, gitHubOrg, p.config().GithubOrgIsUser)
+		if err2 != nil {
+			if p.handleGitHubAuthError(userId, err2) {
+				return
+			}
+			p.SendTodoPost("Error retrieving the GitHub repositories of "+gitHubOrg, dmChannel.Id)
+			return
+		}
+		repos = append(repos, githubRepos...)
 	}
-	for _, repo := range githubRepos {
-		repos = append(repos, repo.GetName())
+
+	// Repositories are scanned concurrently, and the whole scan is given up as
+	// soon as GitHub reports that the rate limit was hit.
+	scanCtx, cancelScan := context.WithCancel(ctx)
+	defer cancelScan()
+
+	var createdBefore time.Time
+	if staleDays > 0 {
+		createdBefore = time.Now().AddDate(0, 0, -staleDays)
 	}
 
 	var prWaitingReviews PullRequestWaitingReviews
+	var lock sync.Mutex
+	var rateLimited int32
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, TODO_CONCURRENCY)
 	for _, repo := range repos {
-		prs, _, err := githubClient.PullRequests.List(ctx, gitHubOrg, repo, nil)
-		if err != nil {
-			p.SendTodoPost("Error retrieving the GitHub PRs List", p.userId, dmChannel.Id)
+		if scanCtx.Err() != nil {
+			break
 		}
-		for _, pull := range prs {
-			prReviewers, _, err := githubClient.PullRequests.ListReviewers(ctx, gitHubOrg, repo, pull.GetNumber(), nil)
-			if err != nil {
-				p.SendTodoPost("Error retrieving the GitHub PRs Reviewers", p.userId, dmChannel.Id)
-			}
-			for _, reviewer := range prReviewers.Users {
-				if reviewer.GetLogin() == me.GetLogin() {
-					prWaitingReviews = append(prWaitingReviews, PullRequestWaitingReview{repo, reviewer.GetLogin(), pull.GetNumber(), pull.GetHTMLURL()})
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(repo *github.Repository) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			reviews, err := p.todoForRepository(scanCtx, githubClient, repo, me.GetLogin(), createdBefore, bases)
+			if isRateLimitError(err) {
+				if atomic.CompareAndSwapInt32(&rateLimited, 0, 1) {
+					cancelScan()
 				}
+				return
+			}
+			if err != nil && scanCtx.Err() == nil {
+				p.SendTodoPost("Error retrieving the GitHub PRs List of "+repo.GetFullName(), dmChannel.Id)
 			}
+
+			lock.Lock()
+			prWaitingReviews = append(prWaitingReviews, reviews...)
+			lock.Unlock()
+		}(repo)
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&rateLimited) == 1 {
+		p.SendTodoPost("GitHub's rate limit was reached while looking for your pending PRs reviews. Try again later.", dmChannel.Id)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	// Snoozed pull requests are left out until their snooze expires.
+	snoozes, snoozeErr := p.getTodoSnoozes(userId)
+	if snoozeErr != nil {
+		fmt.Println("Error retrieving snoozed pull requests: " + snoozeErr.Error())
+	}
+	snoozed := 0
+	unsnoozed := prWaitingReviews[:0]
+	for _, toReview := range prWaitingReviews {
+		if snoozes.isSnoozed(toReview.GitHubRepo, toReview.PullRequestNumber) {
+			snoozed++
+			continue
 		}
+		unsnoozed = append(unsnoozed, toReview)
 	}
+	prWaitingReviews = unsnoozed
+
+	sort.Slice(prWaitingReviews, func(i, j int) bool {
+		if staleDays > 0 {
+			return prWaitingReviews[i].CreatedAt.Before(prWaitingReviews[j].CreatedAt)
+		}
+		if prWaitingReviews[i].GitHubRepo != prWaitingReviews[j].GitHubRepo {
+			return prWaitingReviews[i].GitHubRepo < prWaitingReviews[j].GitHubRepo
+		}
+		return prWaitingReviews[i].PullRequestNumber < prWaitingReviews[j].PullRequestNumber
+	})
 
+	var message string
 	if len(prWaitingReviews) != 0 {
-		var buffer bytes.Buffer
+		message = formatTodo(p.getTodoFormat(userId), prWaitingReviews, staleDays)
+	} else if staleDays > 0 {
+		message = fmt.Sprintf("No PRs older than %v days are waiting for your review.", staleDays)
+	} else {
+		message = "No pending PRs to review. Go and grab a coffee :smile:"
+	}
+	if snoozed > 0 {
+		message += fmt.Sprintf("\n\n%v snoozed PRs were left out. Use `/github snooze list` to see them.", snoozed)
+	}
+	p.SendTodoPost(message, dmChannel.Id)
+}
+
+// formatTodo writes the pull requests waiting for review in the given format,
+// listing those the reviewer requested changes on after the others. When
+// staleDays is set, each pull request's age is shown too.
+func formatTodo(format string, prWaitingReviews PullRequestWaitingReviews, staleDays int) string {
+	var waiting, changesRequested PullRequestWaitingReviews
+	for _, toReview := range prWaitingReviews {
+		if toReview.ChangesRequested {
+			changesRequested = append(changesRequested, toReview)
+		} else {
+			waiting = append(waiting, toReview)
+		}
+	}
+
+	var buffer bytes.Buffer
+	writeTodoSection(&buffer, format, waiting, staleDays)
+	if len(changesRequested) > 0 {
+		buffer.WriteString("\nYou requested changes on these, so they may be waiting on their authors:\n")
+		writeTodoSection(&buffer, format, changesRequested, staleDays)
+	}
+	return strings.TrimPrefix(buffer.String(), "\n")
+}
+
+func writeTodoSection(buffer *bytes.Buffer, format string, prWaitingReviews PullRequestWaitingReviews, staleDays int) {
+	if len(prWaitingReviews) == 0 {
+		return
+	}
+	age := func(toReview PullRequestWaitingReview) int {
+		return int(time.Since(toReview.CreatedAt).Hours() / 24)
+	}
+
+	switch format {
+	case TODO_FORMAT_TABLE:
+		// Tables must be set apart from the text before them.
+		if buffer.Len() > 0 {
+			buffer.WriteString("\n")
+		}
+		if staleDays > 0 {
+			buffer.WriteString("| Repository | PR | Reviewer | Age |\n|---|---|---|---|\n")
+		} else {
+			buffer.WriteString("| Repository | PR | Reviewer |\n|---|---|---|\n")
+		}
+		for _, toReview := range prWaitingReviews {
+			buffer.WriteString(fmt.Sprintf("| %v | [#%v](%v) | %v |", toReview.GitHubRepo, toReview.PullRequestNumber, toReview.PullRequestURL, toReview.GitHubUserName))
+			if staleDays > 0 {
+				buffer.WriteString(fmt.Sprintf(" %v days |", age(toReview)))
+			}
+			buffer.WriteString("\n")
+		}
+	case TODO_FORMAT_COMPACT:
 		for _, toReview := range prWaitingReviews {
-			buffer.WriteString(fmt.Sprintf("[**%v**] PRs waiting %v's review: **PR-%v** url: %v\n", toReview.GitHubRepo, toReview.GitHubUserName, toReview.PullRequestNumber, toReview.PullRequestURL))
+			line := fmt.Sprintf("* [%v#%v](%v)", toReview.GitHubRepo, toReview.PullRequestNumber, toReview.PullRequestURL)
+			if staleDays > 0 {
+				line += fmt.Sprintf(" (%vd)", age(toReview))
+			}
+			buffer.WriteString(line + "\n")
 		}
-		p.SendTodoPost(buffer.String(), p.userId, dmChannel.Id)
+	default:
+		for _, toReview := range prWaitingReviews {
+			line := fmt.Sprintf("[**%v**] PRs waiting %v's review: **PR-%v** url: %v", toReview.GitHubRepo, toReview.GitHubUserName, toReview.PullRequestNumber, toReview.PullRequestURL)
+			if staleDays > 0 {
+				line += fmt.Sprintf(" opened %v days ago", age(toReview))
+			}
+			buffer.WriteString(line + "\n")
+		}
+	}
+}
+
+// TODO_CONCURRENCY is the number of repositories a todo scan looks at at once.
+const TODO_CONCURRENCY = 5
+
+// todoForRepository returns the open pull requests of the repository that are
+// waiting for login's review, skipping those opened after createdBefore unless
+// it is zero, and those into base branches that don't match bases.
+func (p *Plugin) todoForRepository(ctx context.Context, githubClient *github.Client, repo *github.Repository, login string, createdBefore time.Time, bases []string) (PullRequestWaitingReviews, error) {
+	owner := repo.GetOwner().GetLogin()
+	var prs []*github.PullRequest
+	opt := &github.PullRequestListOptions{}
+	_, err := paginate(&opt.ListOptions, 0, func(*github.ListOptions) (int, *github.Response, error) {
+		page, resp, err := githubClient.PullRequests.List(ctx, owner, repo.GetName(), opt)
+		prs = append(prs, page...)
+		return len(page), resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var prWaitingReviews PullRequestWaitingReviews
+	for _, pull := range prs {
+		if !createdBefore.IsZero() && pull.GetCreatedAt().After(createdBefore) {
+			continue
+		}
+		if !matchesBranchPatterns(bases, pull.GetBase().GetRef()) {
+			continue
+		}
+
+		reviewers, err := listRequestedReviewers(ctx, githubClient, owner, repo.GetName(), pull.GetNumber())
+		if isRateLimitError(err) {
+			return nil, err
+		}
+		if err != nil {
+			fmt.Printf("Error retrieving the reviewers of %v#%v: %v\n", repo.GetFullName(), pull.GetNumber(), err.Error())
+			continue
+		}
+		for _, reviewer := range reviewers {
+			if reviewer.GetLogin() != login {
+				continue
+			}
+
+			// A review can still be requested from someone who has already
+			// reviewed, so their latest verdict decides whether it is pending.
+			state, err := latestReviewState(ctx, githubClient, owner, repo.GetName(), pull.GetNumber(), login)
+			if isRateLimitError(err) {
+				return nil, err
+			}
+			if err != nil {
+				fmt.Printf("Error retrieving the reviews of %v#%v: %v\n", repo.GetFullName(), pull.GetNumber(), err.Error())
+			}
+			if state == "APPROVED" {
+				continue
+			}
+
+			prWaitingReviews = append(prWaitingReviews, PullRequestWaitingReview{
+				GitHubRepo:        repo.GetFullName(),
+				GitHubUserName:    reviewer.GetLogin(),
+				PullRequestNumber: pull.GetNumber(),
+				PullRequestURL:    pull.GetHTMLURL(),
+				CreatedAt:         pull.GetCreatedAt(),
+				ChangesRequested:  state == "CHANGES_REQUESTED",
+			})
+		}
+	}
+	return prWaitingReviews, nil
+}
+
+// latestReviewState returns the state of login's latest approval, request for
+// changes or dismissed review of the pull request, or an empty string if they
+// have none. Comments don't change a reviewer's verdict, so they are skipped.
+func latestReviewState(ctx context.Context, githubClient *github.Client, owner, repo string, number int, login string) (string, error) {
+	state := ""
+	_, err := paginate(&github.ListOptions{}, 0, func(opt *github.ListOptions) (int, *github.Response, error) {
+		reviews, resp, err := githubClient.PullRequests.ListReviews(ctx, owner, repo, number, opt)
+		for _, review := range reviews {
+			if !strings.EqualFold(review.GetUser().GetLogin(), login) {
+				continue
+			}
+			switch review.GetState() {
+			case "APPROVED", "CHANGES_REQUESTED", "DISMISSED":
+				state = review.GetState()
+			}
+		}
+		return len(reviews), resp, err
+	})
+	if err != nil {
+		return "", err
+	}
+	return state, nil
+}
+
+func isRateLimitError(err error) bool {
+	switch err.(type) {
+	case *github.RateLimitError, *github.AbuseRateLimitError:
+		return true
+	}
+	return false
+}
+
+// listRequestedReviewers returns every user whose review has been requested on
+// the pull request.
+func listRequestedReviewers(ctx context.Context, githubClient *github.Client, owner, repo string, number int) ([]*github.User, error) {
+	var users []*github.User
+	_, err := paginate(&github.ListOptions{}, 0, func(opt *github.ListOptions) (int, *github.Response, error) {
+		reviewers, resp, err := githubClient.PullRequests.ListReviewers(ctx, owner, repo, number, opt)
+		if reviewers == nil {
+			return 0, resp, err
+		}
+		users = append(users, reviewers.Users...)
+		return len(reviewers.Users), resp, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// listRepositories lists every repository owned by the given organization or,
+// when isUser is set, by the given user account.
+func listRepositories(ctx context.Context, githubClient *github.Client, owner string, isUser bool) ([]*github.Repository, error) {
+	var repos []*github.Repository
+	var err error
+	if isUser {
+		opt := &github.RepositoryListOptions{Type: "owner"}
+		_, err = paginate(&opt.ListOptions, 0, func(*github.ListOptions) (int, *github.Response, error) {
+			page, resp, err := githubClient.Repositories.List(ctx, owner, opt)
+			repos = append(repos, page...)
+			return len(page), resp, err
+		})
 	} else {
-		p.SendTodoPost("No pending PRs to review. Go and grab a coffee :smile:", p.userId, dmChannel.Id)
+		opt := &github.RepositoryListByOrgOptions{}
+		_, err = paginate(&opt.ListOptions, 0, func(*github.ListOptions) (int, *github.Response, error) {
+			page, resp, err := githubClient.Repositories.ListByOrg(ctx, owner, opt)
+			repos = append(repos, page...)
+			return len(page), resp, err
+		})
 	}
+	if err != nil {
+		return nil, err
+	}
+	return repos, nil
 }
 
-func (p *Plugin) SendTodoPost(message, userId, channelId string) {
-	props := map[string]interface{}{}
+// botProps returns post props that display a post with the configured bot
+// name and icon.
+func (p *Plugin) botProps() map[string]interface{} {
+	config := p.config()
+	return map[string]interface{}{
+		"override_username": config.GetBotDisplayName(),
+		"override_icon_url": config.GetBotIconURL(),
+	}
+}
 
-	post := &model.Post{
-		UserId:    userId,
+// newPost builds a plain post by the plugin's user in the channel.
+func (p *Plugin) newPost(channelId, message string) *model.Post {
+	return &model.Post{
+		UserId:    p.getUserId(),
 		ChannelId: channelId,
 		Message:   message,
 		Type:      model.POST_DEFAULT,
-		Props:     props,
+		Props:     p.botProps(),
 	}
-	p.api.CreatePost(post)
 }
 
-func NewString(st string) *string {
-	return &st
+// SendTodoPost posts a todo message as the plugin's user, under the configured
+// bot display name and icon.
+func (p *Plugin) SendTodoPost(message, channelId string) {
+	p.api.CreatePost(p.newPost(channelId, message))
 }
 
-func githubUserListToUsernames(users []*github.User) *[]string {
-	var output []string
+// githubUserListToUsernames returns the sorted logins of the users as a plain
+// slice, skipping nil users and listing each login once. It always returns a
+// non-nil slice so that the prop is an empty list rather than null.
+func githubUserListToUsernames(users []*github.User) []string {
+	output := []string{}
+	seen := map[string]bool{}
 	for _, user := range users {
-		output = append(output, *user.Login)
+		login := user.GetLogin()
+		if login == "" || seen[strings.ToLower(login)] {
+			continue
+		}
+		seen[strings.ToLower(login)] = true
+		output = append(output, login)
 	}
-	return &output
+	sort.Slice(output, func(i, j int) bool {
+		return strings.ToLower(output[i]) < strings.ToLower(output[j])
+	})
+	return output
 }
 
-func processLables(labels []*github.Label) *[]map[string]string {
-	var output []map[string]string
+// processLabels converts GitHub labels into the text and hex color entries the
+// webapp renders as label chips. It always returns a non-nil slice.
+func processLabels(labels []*github.Label) []map[string]string {
+	output := []map[string]string{}
 	for _, label := range labels {
+		if label == nil || label.GetName() == "" {
+			continue
+		}
 		entry := map[string]string{
-			"text":  *label.Name,
-			"color": *label.Color,
+			"text":  label.GetName(),
+			"color": label.GetColor(),
 		}
 		output = append(output, entry)
 	}
 
-	return &output
+	return output
 }
 
-func (p *Plugin) postFromPullRequest(org, repository string, pullRequest *github.PullRequest) *model.Post {
-	props := map[string]interface{}{}
-	props["number"] = fmt.Sprint(*pullRequest.Number)
-	props["summary"] = pullRequest.Body
-	props["title"] = pullRequest.Title
-	props["assignees"] = githubUserListToUsernames(pullRequest.Assignees)
-	prReviewers, _, _ := p.githubClient.PullRequests.ListReviewers(context.Background(), org, repository, pullRequest.GetNumber(), nil)
-	props["reviewers"] = githubUserListToUsernames(prReviewers.Users)
-	//labels, _, _ := p.githubClient.Issues.ListLabelsByIssue(context.Background(), org, repository, pullRequest.GetNumber(), nil)
-	//props["labels"] = processLables(labels)
-	props["submitted_at"] = fmt.Sprint(pullRequest.CreatedAt.Unix())
+// PULL_REQUEST_POST_TYPE is the custom post type the webapp renders, under
+// the same name in webapp/constants.js, as a pull request card from the
+// post's PullRequestProps.
+const PULL_REQUEST_POST_TYPE = "custom_github_pull_request"
 
-	return &model.Post{
-		UserId:  p.userId,
-		Message: "Joram screwed up",
-		Type:    "custom_github_pull_request",
-		Props:   props,
+// PullRequestProps is the schema of the props of a pull request post. Every
+// field is a plain value so that the props serialize the same way each time.
+//
+//	org, repo      the repository the pull request belongs to
+//	number         the pull request number
+//	title, summary the title and Markdown body
+//	state          open, closed or merged
+//	author         the login of the user who opened it
+//	author_avatar_url
+//	               their avatar, or empty if GitHub didn't give one
+//	html_url       the pull request's page on GitHub
+//	labels         {text, color} entries, with color in hex without a #
+//	reviewers      logins whose review has been requested
+//	assignees      logins it is assigned to
+//	labels_more, reviewers_more, assignees_more
+//	               how many labels, reviewers or assignees were left out of
+//	               the lists above, which hold at most MaxListItems each
+//	additions, deletions, changed_files
+//	               the size of the change
+//	submitted_at   when it was opened, in seconds since the epoch
+//	milestone, milestone_url, milestone_due_on
+//	               its milestone, if it has one, with the due date in
+//	               seconds since the epoch or 0 if there is none
+type PullRequestProps struct {
+	Org          string
+	Repo         string
+	Number       int
+	Title        string
+	Summary      string
+	State        string
+	Author       string
+	AuthorAvatar string
+	HTMLURL      string
+	Labels       []map[string]string
+	Reviewers    []string
+	Assignees    []string
+	Additions    int
+	Deletions    int
+	ChangedFiles int
+	SubmittedAt  int64
+
+	LabelsMore    int
+	ReviewersMore int
+	AssigneesMore int
+
+	// Milestone is empty when the pull request has no milestone, and
+	// MilestoneDueOn is zero when the milestone has no due date.
+	Milestone      string
+	MilestoneURL   string
+	MilestoneDueOn int64
+}
+
+// addTo sets the schema's keys in the post props.
+func (pr *PullRequestProps) addTo(props map[string]interface{}) {
+	props["org"] = pr.Org
+	props["repo"] = pr.Repo
+	props["number"] = pr.Number
+	props["title"] = pr.Title
+	props["summary"] = pr.Summary
+	props["state"] = pr.State
+	props["author"] = pr.Author
+	props["author_avatar_url"] = pr.AuthorAvatar
+	props["html_url"] = pr.HTMLURL
+	props["labels"] = pr.Labels
+	props["reviewers"] = pr.Reviewers
+	props["assignees"] = pr.Assignees
+	props["labels_more"] = pr.LabelsMore
+	props["reviewers_more"] = pr.ReviewersMore
+	props["assignees_more"] = pr.AssigneesMore
+	props["additions"] = pr.Additions
+	props["deletions"] = pr.Deletions
+	props["changed_files"] = pr.ChangedFiles
+	props["submitted_at"] = pr.SubmittedAt
+	if pr.Milestone != "" {
+		props["milestone"] = pr.Milestone
+		props["milestone_url"] = pr.MilestoneURL
+		props["milestone_due_on"] = pr.MilestoneDueOn
 	}
 }
 
-func (p *Plugin) handleWebhook(w http.ResponseWriter, r *http.Request) {
-	config := p.config()
+// describeMilestone describes the milestone and its due date for a post
+// message, or returns an empty string if there is no milestone.
+func describeMilestone(milestone *github.Milestone) string {
+	if milestone.GetTitle() == "" {
+		return ""
+	}
+	if dueOn := milestone.GetDueOn(); !dueOn.IsZero() {
+		return fmt.Sprintf("milestone %v, due %v", milestone.GetTitle(), dueOn.Format("2006-01-02"))
+	}
+	return "milestone " + milestone.GetTitle()
+}
 
-	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(config.WebhookSecret)) != 1 {
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
-		return
+// MAX_SUMMARY_LENGTH caps the length of the description shown in a post.
+const MAX_SUMMARY_LENGTH = 4000
+
+// truncateList keeps the first max items and summarizes the rest with a final
+// "+N more" entry.
+func truncateList(items []string, max int) []string {
+	if len(items) <= max {
+		return items
 	}
+	return append(items[:max:max], fmt.Sprintf("+%v more", len(items)-max))
+}
 
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Bad request body", http.StatusBadRequest)
-		return
+// limitList keeps the first max items for a list prop and returns how many
+// were left out, for the matching _more prop.
+func limitList(items []string, max int) ([]string, int) {
+	if len(items) <= max {
+		return items, 0
 	}
+	return items[:max:max], len(items) - max
+}
 
-	/*payload, err := github.ValidatePayload(r, []byte(config.WebhookSecret))
-	if err != nil {
-		fmt.Println("Err: " + err.Error())
-	}*/
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		fmt.Println("Err: " + err.Error())
+// limitLabels is limitList for label entries.
+func limitLabels(labels []map[string]string, max int) ([]map[string]string, int) {
+	if len(labels) <= max {
+		return labels, 0
 	}
-	event, err := github.ParseWebHook(github.WebHookType(r), body)
-	if err != nil {
-		fmt.Println("Err2: " + err.Error())
+	return labels[:max:max], len(labels) - max
+}
+
+// truncateText shortens text longer than max runes, marking where it was cut.
+func truncateText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
 	}
-	switch event := event.(type) {
-	case *github.PullRequestEvent:
-		fmt.Println("Stufff")
-		fmt.Println(*event)
-		fmt.Println(*event.Repo)
-		p.pullRequestOpened(event.GetRepo().GetFullName(), event.PullRequest)
+	return string(runes[:max]) + "…"
+}
+
+// pullRequestState returns open, closed or merged.
+func pullRequestState(pullRequest *github.PullRequest) string {
+	if pullRequest.GetMerged() {
+		return STATE_MERGED
 	}
+	return pullRequest.GetState()
 }
 
-func (p *Plugin) pullRequestOpened(repo string, pullRequest *github.PullRequest) {
-	subscriptions, err := NewSubscriptionsFromKVStore(p.api.KeyValueStore())
-	if err != nil {
-		fmt.Println("Error: " + err.Error())
+// postFromPullRequest builds the detailed post for the pull request. Looking up
+// its reviewers is left to the caller unless withReviewers is set.
+func (p *Plugin) postFromPullRequest(org, repository string, pullRequest *github.PullRequest, withReviewers bool) *model.Post {
+	pr := &PullRequestProps{
+		Org:          org,
+		Repo:         repository,
+		Number:       pullRequest.GetNumber(),
+		Title:        pullRequest.GetTitle(),
+		Summary:      truncateText(pullRequest.GetBody(), MAX_SUMMARY_LENGTH),
+		State:        pullRequestState(pullRequest),
+		Author:       pullRequest.GetUser().GetLogin(),
+		AuthorAvatar: pullRequest.GetUser().GetAvatarURL(),
+		HTMLURL:      pullRequest.GetHTMLURL(),
+		Reviewers:    []string{},
+		Assignees:    githubUserListToUsernames(pullRequest.Assignees),
+		SubmittedAt:  pullRequest.GetCreatedAt().Unix(),
+	}
+
+	if milestone := pullRequest.GetMilestone(); milestone.GetTitle() != "" {
+		pr.Milestone = milestone.GetTitle()
+		pr.MilestoneURL = milestone.GetHTMLURL()
+		if dueOn := milestone.GetDueOn(); !dueOn.IsZero() {
+			pr.MilestoneDueOn = dueOn.Unix()
+		}
 	}
-	fmt.Println("Subscriptions:")
-	fmt.Println(*subscriptions)
-	fmt.Println("Repo: " + repo)
 
-	gob.Register([]map[string]string{})
+	// The post is made without whatever can't be fetched, and without trying
+	// at all while enrichmentBreaker is open.
+	if withReviewers {
+		if reviewers, ok := p.fetchReviewers(org, repository, pullRequest.GetNumber()); ok {
+			pr.Reviewers = reviewers
+		}
+	}
 
-	channels := subscriptions.GetChannelsForRepository(repo)
-	values := strings.Split(repo, "/")
-	post := p.postFromPullRequest(values[0], values[1], pullRequest)
-	for _, channel := range channels {
-		post.ChannelId = channel
-		_, err := p.api.CreatePost(post)
-		fmt.Println("Chan: " + channel)
+	var labels []*github.Label
+	if p.enrichmentBreaker.allow() {
+		var err error
+		labels, err = listIssueLabels(context.Background(), p.githubClient, org, repository, pullRequest.GetNumber())
+		p.enrichmentBreaker.record(err)
 		if err != nil {
-			fmt.Println("Chanerr: " + err.Error())
+			fmt.Println("Error retrieving labels: " + err.Error())
 		}
 	}
+	pr.Labels = processLabels(labels)
+
+	// Pull requests from list calls don't carry their diff stats, so fetch the
+	// full pull request when they are missing.
+	stats := pullRequest
+	if (stats.Additions == nil || stats.Deletions == nil || stats.ChangedFiles == nil) && p.enrichmentBreaker.allow() {
+		fullPullRequest, _, err := p.githubClient.PullRequests.Get(context.Background(), org, repository, pullRequest.GetNumber())
+		p.enrichmentBreaker.record(err)
+		if err != nil {
+			fmt.Println("Error retrieving pull request stats: " + err.Error())
+		} else {
+			stats = fullPullRequest
+		}
+	}
+	pr.Additions = stats.GetAdditions()
+	pr.Deletions = stats.GetDeletions()
+	pr.ChangedFiles = stats.GetChangedFiles()
+
+	maxListItems := p.config().GetMaxListItems()
+	pr.Labels, pr.LabelsMore = limitLabels(pr.Labels, maxListItems)
+	pr.Reviewers, pr.ReviewersMore = limitList(pr.Reviewers, maxListItems)
+	pr.Assignees, pr.AssigneesMore = limitList(pr.Assignees, maxListItems)
+
+	props := p.botProps()
+	pr.addTo(props)
+
+	message := fmt.Sprintf("[%v/%v#%v %v](%v)", org, repository, pr.Number, pr.Title, pr.HTMLURL)
+	if milestone := describeMilestone(pullRequest.GetMilestone()); milestone != "" {
+		message += " (" + milestone + ")"
+	}
+
+	return &model.Post{
+		UserId:  p.getUserId(),
+		Message: message,
+		Type:    PULL_REQUEST_POST_TYPE,
+		Props:   props,
+	}
+}
+
+// fetchReviewers returns the logins whose review has been requested on the
+// pull request. It returns false if they couldn't be fetched.
+func (p *Plugin) fetchReviewers(org, repository string, number int) ([]string, bool) {
+	if !p.enrichmentBreaker.allow() {
+		return nil, false
+	}
+
+	users, err := listRequestedReviewers(context.Background(), p.githubClient, org, repository, number)
+	p.enrichmentBreaker.record(err)
+	if err != nil {
+		fmt.Println("Error retrieving reviewers: " + err.Error())
+		return nil, false
+	}
+	return githubUserListToUsernames(users), true
 }
 
 type AddReviewersToPR struct {
@@ -362,40 +939,125 @@ type AddReviewersToPR struct {
 	Org           string   `json:"org"`
 	Repo          string   `json:"repo"`
 	Reviewers     []string `json:"reviewers"`
+	TeamReviewers []string `json:"team_reviewers"`
+
+	// ChannelId, when set, is a channel the requester is a member of that is
+	// told who was asked to review.
+	ChannelId string `json:"channel_id,omitempty"`
 }
 
 func (p *Plugin) handleReviewers(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 	var req AddReviewersToPR
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(req.Reviewers) == 0 && len(req.TeamReviewers) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "At least one reviewer or team reviewer is required")
 		return
 	}
 
 	userId := r.Header.Get("Mattermost-User-Id")
 	if userId == "" {
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		writeJSONError(w, http.StatusUnauthorized, "Not authorized")
 		return
 	}
 
-	b, err := p.api.KeyValueStore().Get(userId + GITHUB_TOKEN_KEY)
+	gitHubUserToken, err := p.getUserToken(userId)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, describeUserTokenError("Unable to get your GitHub token", err))
 		return
 	}
-	gitHubUserToken := string(b)
 
 	githubClient := githubConnect(gitHubUserToken)
 
+	// A single unknown login makes GitHub reject the whole request, so check
+	// them first and request the rest. Only a login GitHub doesn't find is
+	// unknown; any other failure fails the request, rather than dropping
+	// reviewers who exist.
+	var valid []string
+	invalid := []string{}
+	for _, login := range req.Reviewers {
+		if _, _, err := githubClient.Users.Get(ctx, login); err != nil {
+			if p.handleGitHubAuthError(userId, err) {
+				writeJSONError(w, http.StatusUnauthorized, "GitHub token expired or revoked")
+				return
+			}
+			if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound {
+				invalid = append(invalid, login)
+				continue
+			}
+			writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("Unable to look up the GitHub user %v: %v", login, err.Error()))
+			return
+		}
+		valid = append(valid, login)
+	}
+
+	if len(valid) == 0 && len(req.TeamReviewers) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "Unknown GitHub users: "+strings.Join(invalid, ", "))
+		return
+	}
+
 	reviewers := github.ReviewersRequest{
-		Reviewers: req.Reviewers,
+		Reviewers:     valid,
+		TeamReviewers: req.TeamReviewers,
 	}
 
 	pr, _, err2 := githubClient.PullRequests.RequestReviewers(ctx, req.Org, req.Repo, req.PullRequestId, reviewers)
 	if err2 != nil {
-		http.Error(w, err2.Error(), http.StatusBadRequest)
+		if p.handleGitHubAuthError(userId, err2) {
+			writeJSONError(w, http.StatusUnauthorized, "GitHub token expired or revoked")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, err2.Error())
+		return
+	}
+
+	if req.ChannelId != "" {
+		p.postReviewersRequested(userId, req.ChannelId, req.Org, req.Repo, pr, append(valid, req.TeamReviewers...))
+	}
+
+	// The reviewers requested before are listed too, unless GitHub fails to
+	// list them, in which case only the new ones are.
+	requested := append([]string{}, valid...)
+	if users, err := listRequestedReviewers(ctx, githubClient, req.Org, req.Repo, req.PullRequestId); err == nil {
+		requested = githubUserListToUsernames(users)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&AddReviewersResponse{
+		HTMLURL:          pr.GetHTMLURL(),
+		Reviewers:        requested,
+		InvalidReviewers: invalid,
+	})
+}
+
+// postReviewersRequested tells the channel that the user asked the reviewers
+// to review the pull request. Only members of the channel can post to it.
+func (p *Plugin) postReviewersRequested(userId, channelId, org, repo string, pullRequest *github.PullRequest, reviewers []string) {
+	if _, err := p.api.GetChannelMember(channelId, userId); err != nil {
+		return
+	}
+	user, err := p.api.GetUser(userId)
+	if err != nil {
+		fmt.Println("Error getting the user: " + err.Error())
 		return
 	}
 
-	w.Write([]byte(fmt.Sprintf("%v", pr.GetHTMLURL())))
+	message := fmt.Sprintf("@%v requested review from %v on [%v/%v#%v %v](%v)",
+		user.Username, strings.Join(reviewers, ", "), org, repo,
+		pullRequest.GetNumber(), pullRequest.GetTitle(), pullRequest.GetHTMLURL())
+	if _, err := p.api.CreatePost(p.newPost(channelId, message)); err != nil {
+		fmt.Println("Error posting the review request: " + err.Error())
+	}
+}
+
+// AddReviewersResponse reports the pull request's requested reviewers after a
+// request to add some, and which of the logins given don't exist.
+type AddReviewersResponse struct {
+	HTMLURL          string   `json:"html_url"`
+	Reviewers        []string `json:"reviewers"`
+	InvalidReviewers []string `json:"invalid_reviewers"`
 }
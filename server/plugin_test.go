@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const testBotUserId = "botuserid"
+
+// testKVStore is an in-memory plugin.KeyValueStore. Like the server's, it
+// returns a nil value for keys that were never set. Reads and writes yield
+// first, so that concurrent callers interleave as they do over the network.
+type testKVStore struct {
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+func (s *testKVStore) Set(key string, value []byte) *model.AppError {
+	runtime.Gosched()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.values == nil {
+		s.values = map[string][]byte{}
+	}
+	s.values[key] = value
+	return nil
+}
+
+func (s *testKVStore) Get(key string) ([]byte, *model.AppError) {
+	runtime.Gosched()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.values[key], nil
+}
+
+func (s *testKVStore) Delete(key string) *model.AppError {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+// testAPI fakes the parts of plugin.API the tests reach. Calling anything
+// else panics on the nil embedded interface.
+type testAPI struct {
+	plugin.API
+
+	kv testKVStore
+
+	lock           sync.Mutex
+	posts          []*model.Post
+	directChannels [][2]string
+}
+
+func (api *testAPI) KeyValueStore() plugin.KeyValueStore {
+	return &api.kv
+}
+
+func (api *testAPI) GetUserByUsername(name string) (*model.User, *model.AppError) {
+	return &model.User{Id: testBotUserId, Username: name}, nil
+}
+
+func (api *testAPI) GetDirectChannel(userId1, userId2 string) (*model.Channel, *model.AppError) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	api.directChannels = append(api.directChannels, [2]string{userId1, userId2})
+	return &model.Channel{Id: userId1 + "__" + userId2, Type: model.CHANNEL_DIRECT}, nil
+}
+
+func (api *testAPI) CreatePost(post *model.Post) (*model.Post, *model.AppError) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	created := *post
+	created.Id = model.NewId()
+	api.posts = append(api.posts, &created)
+	return &created, nil
+}
+
+// postChannels returns the channel of each post made, in order.
+func (api *testAPI) postChannels() []string {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	channels := []string{}
+	for _, post := range api.posts {
+		channels = append(channels, post.ChannelId)
+	}
+	return channels
+}
+
+// newTestPlugin returns a plugin talking to the api, configured with a token,
+// an org, a user to post as and a webhook secret.
+func newTestPlugin(api *testAPI) *Plugin {
+	p := &Plugin{api: api}
+	p.configuration.Store(&Configuration{
+		GithubToken:   "token",
+		GithubOrg:     "owner",
+		Username:      "github",
+		WebhookSecret: "secret",
+	})
+	return p
+}
+
+// newTestGitHubClient returns a client for the GitHub API served by handler.
+// The caller closes the server.
+func newTestGitHubClient(handler http.Handler) (*github.Client, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client, server
+}
+
+// subscribeForTest stores the subscription to the repository.
+func subscribeForTest(t *testing.T, p *Plugin, repository string, subscription *Subscription) {
+	subscriptions, err := NewSubscriptionsForChannelFromKVStore(p.api.KeyValueStore(), subscription.ChannelId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriptions.Add(repository, subscription)
+	if err := subscriptions.StoreInKVStore(p.api.KeyValueStore()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostFromPullRequestPropTypes(t *testing.T) {
+	p := newTestPlugin(&testAPI{})
+	client, server := newTestGitHubClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name": "bug", "color": "ff0000"}]`))
+	}))
+	defer server.Close()
+	p.githubClient = client
+
+	createdAt := time.Unix(1500000000, 0)
+	pullRequest := &github.PullRequest{
+		Number:       github.Int(1),
+		Title:        github.String("Title"),
+		Body:         github.String("Body"),
+		State:        github.String("open"),
+		User:         &github.User{Login: github.String("author")},
+		Assignees:    []*github.User{{Login: github.String("assignee")}},
+		Additions:    github.Int(1),
+		Deletions:    github.Int(2),
+		ChangedFiles: github.Int(3),
+		CreatedAt:    &createdAt,
+	}
+	props := p.postFromPullRequest("owner", "repo", pullRequest, false).Props
+
+	for _, tc := range []struct {
+		key   string
+		value interface{}
+	}{
+		{"org", "owner"},
+		{"repo", "repo"},
+		{"number", 1},
+		{"title", "Title"},
+		{"summary", "Body"},
+		{"state", "open"},
+		{"author", "author"},
+		{"labels", []map[string]string{{"text": "bug", "color": "ff0000"}}},
+		{"reviewers", []string{}},
+		{"assignees", []string{"assignee"}},
+		{"labels_more", 0},
+		{"reviewers_more", 0},
+		{"assignees_more", 0},
+		{"additions", 1},
+		{"deletions", 2},
+		{"changed_files", 3},
+		{"submitted_at", int64(1500000000)},
+	} {
+		if value := props[tc.key]; !reflect.DeepEqual(value, tc.value) {
+			t.Errorf("%v is %#v, want %#v", tc.key, value, tc.value)
+		}
+	}
+}
+
+func TestGithubUserListToUsernames(t *testing.T) {
+	user := func(login string) *github.User {
+		return &github.User{Login: github.String(login)}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		users []*github.User
+		want  []string
+	}{
+		{"none", nil, []string{}},
+		{"sorted", []*github.User{user("carol"), user("alice"), user("Bob")}, []string{"alice", "Bob", "carol"}},
+		{"duplicates", []*github.User{user("alice"), user("bob"), user("alice")}, []string{"alice", "bob"}},
+		{"duplicates differing by case", []*github.User{user("Alice"), user("alice")}, []string{"Alice"}},
+		{"nil users", []*github.User{nil, user("alice"), nil}, []string{"alice"}},
+		{"nil and empty logins", []*github.User{{}, user(""), user("alice")}, []string{"alice"}},
+	} {
+		if got := githubUserListToUsernames(tc.users); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %#v, want %#v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLatestReviewState(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		reviews string
+		want    string
+	}{
+		{"no reviews", `[]`, ""},
+		{"approved", `[{"user": {"login": "reviewer"}, "state": "APPROVED"}]`, "APPROVED"},
+		{"only comments", `[{"user": {"login": "reviewer"}, "state": "COMMENTED"}]`, ""},
+		{"approved then changes requested then commented", `[
+			{"user": {"login": "reviewer"}, "state": "APPROVED"},
+			{"user": {"login": "reviewer"}, "state": "CHANGES_REQUESTED"},
+			{"user": {"login": "reviewer"}, "state": "COMMENTED"}
+		]`, "CHANGES_REQUESTED"},
+		{"changes requested then approved", `[
+			{"user": {"login": "reviewer"}, "state": "CHANGES_REQUESTED"},
+			{"user": {"login": "reviewer"}, "state": "APPROVED"}
+		]`, "APPROVED"},
+		{"dismissed", `[
+			{"user": {"login": "reviewer"}, "state": "APPROVED"},
+			{"user": {"login": "reviewer"}, "state": "DISMISSED"}
+		]`, "DISMISSED"},
+		{"other reviewers", `[
+			{"user": {"login": "reviewer"}, "state": "APPROVED"},
+			{"user": {"login": "other"}, "state": "CHANGES_REQUESTED"}
+		]`, "APPROVED"},
+		{"only other reviewers", `[{"user": {"login": "other"}, "state": "APPROVED"}]`, ""},
+		{"login differing by case", `[{"user": {"login": "Reviewer"}, "state": "APPROVED"}]`, "APPROVED"},
+	} {
+		client, server := newTestGitHubClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/repos/owner/repo/pulls/1/reviews" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(tc.reviews))
+		}))
+
+		state, err := latestReviewState(context.Background(), client, "owner", "repo", 1, "reviewer")
+		server.Close()
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+		} else if state != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.name, state, tc.want)
+		}
+	}
+}
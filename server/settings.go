@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	TODO_FORMAT_KEY  = "_todoformat"
+	MENTIONS_OFF_KEY = "_mentionsoff"
+
+	// Formats the todo DM can be written in. TODO_FORMAT_DEFAULT writes a
+	// sentence per pull request.
+	TODO_FORMAT_DEFAULT = "default"
+	TODO_FORMAT_COMPACT = "compact"
+	TODO_FORMAT_TABLE   = "table"
+)
+
+var todoFormats = []string{TODO_FORMAT_DEFAULT, TODO_FORMAT_COMPACT, TODO_FORMAT_TABLE}
+
+// getTodoFormat returns the format the user chose for their todo DM, or
+// TODO_FORMAT_DEFAULT if they haven't chosen one.
+func (p *Plugin) getTodoFormat(userId string) string {
+	b, err := p.api.KeyValueStore().Get(userId + TODO_FORMAT_KEY)
+	if err != nil || len(b) == 0 {
+		return TODO_FORMAT_DEFAULT
+	}
+	return string(b)
+}
+
+func (p *Plugin) setTodoFormat(userId, format string) error {
+	if format == TODO_FORMAT_DEFAULT {
+		if err := p.api.KeyValueStore().Delete(userId + TODO_FORMAT_KEY); err != nil {
+			return err
+		}
+		return nil
+	}
+	if err := p.api.KeyValueStore().Set(userId+TODO_FORMAT_KEY, []byte(format)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getMentionsEnabled reports whether the user wants a DM when they are
+// mentioned in a GitHub comment, which they do unless they turned it off.
+func (p *Plugin) getMentionsEnabled(userId string) bool {
+	b, err := p.api.KeyValueStore().Get(userId + MENTIONS_OFF_KEY)
+	return err == nil && len(b) == 0
+}
+
+func (p *Plugin) setMentionsEnabled(userId string, enabled bool) error {
+	if enabled {
+		if err := p.api.KeyValueStore().Delete(userId + MENTIONS_OFF_KEY); err != nil {
+			return err
+		}
+		return nil
+	}
+	if err := p.api.KeyValueStore().Set(userId+MENTIONS_OFF_KEY, []byte("true")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// updateSettings handles `/github settings`, which shows the user's settings
+// or changes one of them.
+func (p *Plugin) updateSettings(userId string, parameters []string) string {
+	if len(parameters) == 0 {
+		mentions := "on"
+		if !p.getMentionsEnabled(userId) {
+			mentions = "off"
+		}
+		return fmt.Sprintf("Your settings:\n* todo-format: %v\n* mentions: %v", p.getTodoFormat(userId), mentions)
+	}
+	if len(parameters) != 2 {
+		return "Wrong number of parameters."
+	}
+
+	switch parameters[0] {
+	case "todo-format":
+		format := strings.ToLower(parameters[1])
+		valid := false
+		for _, f := range todoFormats {
+			valid = valid || f == format
+		}
+		if !valid {
+			return fmt.Sprintf("**%v** is not a todo format. Use one of: %v.", parameters[1], strings.Join(todoFormats, ", "))
+		}
+		if err := p.setTodoFormat(userId, format); err != nil {
+			return "Unable to save your settings: " + err.Error()
+		}
+		return fmt.Sprintf("Your todo will be written in the %v format.", format)
+	case "mentions":
+		if parameters[1] != "on" && parameters[1] != "off" {
+			return "Use `on` or `off`."
+		}
+		if err := p.setMentionsEnabled(userId, parameters[1] == "on"); err != nil {
+			return "Unable to save your settings: " + err.Error()
+		}
+		if parameters[1] == "on" {
+			return "You'll get a message when you are mentioned in a GitHub comment."
+		}
+		return "You won't get messages when you are mentioned in GitHub comments."
+	}
+	return fmt.Sprintf("**%v** is not a setting. Use `todo-format` or `mentions`.", parameters[0])
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const TODO_SNOOZES_KEY = "_todosnoozes"
+
+// todoSnoozes maps the owner/repo#number of each pull request a user snoozed
+// to when it comes back in their todo, in Unix seconds.
+type todoSnoozes map[string]int64
+
+// getTodoSnoozes returns the user's snoozes that haven't expired yet.
+func (p *Plugin) getTodoSnoozes(userId string) (todoSnoozes, error) {
+	snoozes := todoSnoozes{}
+	b, err := p.api.KeyValueStore().Get(userId + TODO_SNOOZES_KEY)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &snoozes); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().Unix()
+	for reference, until := range snoozes {
+		if until <= now {
+			delete(snoozes, reference)
+		}
+	}
+	return snoozes, nil
+}
+
+func (p *Plugin) storeTodoSnoozes(userId string, snoozes todoSnoozes) error {
+	if len(snoozes) == 0 {
+		if err := p.api.KeyValueStore().Delete(userId + TODO_SNOOZES_KEY); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(snoozes)
+	if err != nil {
+		return err
+	}
+	if err := p.api.KeyValueStore().Set(userId+TODO_SNOOZES_KEY, b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// isSnoozed reports whether the pull request is kept out of the todo.
+func (s todoSnoozes) isSnoozed(repo string, number int) bool {
+	_, ok := s[strings.ToLower(fmt.Sprintf("%v#%v", repo, number))]
+	return ok
+}
+
+// parseSnoozeDuration parses how long to snooze for, such as 2d or 12h.
+func parseSnoozeDuration(value string) (time.Duration, error) {
+	unit := time.Hour
+	switch {
+	case strings.HasSuffix(value, "d"):
+		unit = 24 * time.Hour
+	case !strings.HasSuffix(value, "h"):
+		return 0, fmt.Errorf("**%v** is not a valid duration. Give it in days or hours, such as `2d` or `12h`.", value)
+	}
+
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("**%v** is not a valid duration. Give it in days or hours, such as `2d` or `12h`.", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// snooze handles `/github snooze`, which keeps a pull request out of the
+// user's todo for a while, or lists the pull requests they snoozed.
+func (p *Plugin) snooze(userId string, parameters []string) string {
+	snoozes, err := p.getTodoSnoozes(userId)
+	if err != nil {
+		return "Unable to load your snoozed pull requests: " + err.Error()
+	}
+
+	if len(parameters) == 1 && parameters[0] == "list" {
+		if len(snoozes) == 0 {
+			return "You haven't snoozed any pull requests."
+		}
+		references := make([]string, 0, len(snoozes))
+		for reference := range snoozes {
+			references = append(references, reference)
+		}
+		sort.Strings(references)
+
+		lines := []string{"Snoozed pull requests:"}
+		for _, reference := range references {
+			lines = append(lines, fmt.Sprintf("* **%v** until %v", reference, time.Unix(snoozes[reference], 0).UTC().Format("Jan 2 15:04 MST")))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	if len(parameters) != 2 {
+		return "Wrong number of parameters."
+	}
+	owner, repo, number, err := parseIssueReference(parameters[0])
+	if err != nil {
+		return err.Error()
+	}
+	reference := strings.ToLower(fmt.Sprintf("%v/%v#%v", owner, repo, number))
+
+	if parameters[1] == "off" {
+		if _, ok := snoozes[reference]; !ok {
+			return fmt.Sprintf("**%v** isn't snoozed.", reference)
+		}
+		delete(snoozes, reference)
+		if err := p.storeTodoSnoozes(userId, snoozes); err != nil {
+			return "Unable to save your snoozed pull requests: " + err.Error()
+		}
+		return fmt.Sprintf("**%v** is back in your todo.", reference)
+	}
+
+	duration, err := parseSnoozeDuration(parameters[1])
+	if err != nil {
+		return err.Error()
+	}
+	until := time.Now().Add(duration)
+	snoozes[reference] = until.Unix()
+	if err := p.storeTodoSnoozes(userId, snoozes); err != nil {
+		return "Unable to save your snoozed pull requests: " + err.Error()
+	}
+	return fmt.Sprintf("**%v** won't be in your todo until %v.", reference, until.UTC().Format("Jan 2 15:04 MST"))
+}
@@ -1,68 +1,528 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
 
-	"github.com/mattermost/mattermost-server/plugin"
+// Events a subscription can ask to have posted to its channel.
+const (
+	EVENT_PULLS       = "pulls"
+	EVENT_REVIEWS     = "reviews"
+	EVENT_DISCUSSIONS = "discussions"
+	EVENT_REFS        = "refs"
+	EVENT_ISSUES      = "issues"
 )
 
+var knownEvents = []string{EVENT_PULLS, EVENT_REVIEWS, EVENT_DISCUSSIONS, EVENT_REFS, EVENT_ISSUES}
+
+// defaultEvents are posted to subscriptions that don't list any events.
+var defaultEvents = []string{EVENT_PULLS}
+
+// orgRouteEvents are posted to the channel an organization is routed to. They
+// are the events routes were introduced with, so that events added since, such
+// as refs, stay off for routes, as they do for subscriptions.
+var orgRouteEvents = []string{EVENT_PULLS, EVENT_REVIEWS}
+
+// Formats a subscription's posts can take.
 const (
-	SUBSCRIPTIONS_KEY = "subscriptions"
+	FORMAT_DETAILED = "detailed"
+	FORMAT_COMPACT  = "compact"
 )
 
-type Subscriptions struct {
-	Repositories map[string][]string
+// Subscription is a single channel's subscription to a repository.
+type Subscription struct {
+	ChannelId string
+
+	// Events lists the kinds of events posted to the channel. When empty, the
+	// defaultEvents are posted.
+	Events []string
+
+	// Format is FORMAT_COMPACT for single line posts. When empty, posts are
+	// detailed.
+	Format string `json:",omitempty"`
+
+	// IgnoreBots skips pull requests opened by bots.
+	IgnoreBots bool `json:",omitempty"`
+
+	// Authors, when not empty, are the only lower case logins whose pull
+	// requests are posted. Otherwise those of ExcludeAuthors are skipped.
+	Authors        []string `json:",omitempty"`
+	ExcludeAuthors []string `json:",omitempty"`
+
+	// Bases, when not empty, are the only base branches, as path.Match
+	// patterns such as release/*, whose pull requests are posted.
+	Bases []string `json:",omitempty"`
+
+	// Assignees, when not empty, are the only lower case logins whose issues
+	// are posted, when they are opened or assigned to one of them.
+	Assignees []string `json:",omitempty"`
+
+	// Teams, when not empty, are the lower case names of the only teams the
+	// channel gets posts in, so that they stop if it moves to another team.
+	Teams []string `json:",omitempty"`
+
+	// Digest is DIGEST_HOURLY or DIGEST_DAILY to collect the channel's posts
+	// into a periodic summary. When empty, they are posted as they happen.
+	Digest string `json:",omitempty"`
+
+	// Mention is prepended to posts of pull requests carrying any of the
+	// lower case MentionLabels, such as @security-team for security.
+	Mention       string   `json:",omitempty"`
+	MentionLabels []string `json:",omitempty"`
 }
 
-func NewSubscriptionsFromKVStore(store plugin.KeyValueStore) (*Subscriptions, error) {
-	var subscriptions *Subscriptions
+// UnmarshalJSON also accepts the bare channel id that subscriptions were
+// stored as before they carried any options.
+func (s *Subscription) UnmarshalJSON(data []byte) error {
+	var channelId string
+	if err := json.Unmarshal(data, &channelId); err == nil {
+		*s = Subscription{ChannelId: channelId}
+		return nil
+	}
+
+	type subscription Subscription
+	return json.Unmarshal(data, (*subscription)(s))
+}
 
-	value, err := store.Get(SUBSCRIPTIONS_KEY)
-	if err != nil {
-		return nil, err
+func (s *Subscription) GetEvents() []string {
+	if len(s.Events) == 0 {
+		return defaultEvents
 	}
+	return s.Events
+}
 
-	if value == nil {
-		subscriptions = &Subscriptions{}
-	} else {
-		json.NewDecoder(bytes.NewReader(value)).Decode(&subscriptions)
+func (s *Subscription) HasEvent(event string) bool {
+	for _, e := range s.GetEvents() {
+		if e == event {
+			return true
+		}
 	}
+	return false
+}
 
-	return subscriptions, nil
+func (s *Subscription) GetFormat() string {
+	if s.Format == "" {
+		return FORMAT_DETAILED
+	}
+	return s.Format
 }
 
-func (s *Subscriptions) StoreInKVStore(store plugin.KeyValueStore) error {
-	b, err := json.Marshal(s)
-	if err != nil {
-		return err
+// AllowsAuthor reports whether the subscription posts pull requests opened by
+// the login.
+func (s *Subscription) AllowsAuthor(login string) bool {
+	login = strings.ToLower(login)
+	if len(s.Authors) > 0 {
+		return containsString(s.Authors, login)
 	}
-	store.Set(SUBSCRIPTIONS_KEY, b)
-	return nil
+	return !containsString(s.ExcludeAuthors, login)
+}
+
+// AllowsAssignees reports whether the subscription posts issues assigned to
+// the logins.
+func (s *Subscription) AllowsAssignees(logins []string) bool {
+	if len(s.Assignees) == 0 {
+		return true
+	}
+	for _, login := range logins {
+		if containsString(s.Assignees, strings.ToLower(login)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsBase reports whether pull requests into the base branch are posted.
+func (s *Subscription) AllowsBase(ref string) bool {
+	return matchesBranchPatterns(s.Bases, ref)
+}
+
+// matchesBranchPatterns reports whether the branch matches any of the
+// patterns, or whether there are none.
+func matchesBranchPatterns(patterns []string, branch string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// MentionFor returns the mention to prepend to the post of a pull request with
+// the labels, or an empty string if it doesn't call for one.
+func (s *Subscription) MentionFor(labels []string) string {
+	for _, label := range labels {
+		if containsString(s.MentionLabels, strings.ToLower(label)) {
+			return s.Mention
+		}
+	}
+	return ""
+}
+
+// parseMentionOn parses the condition and mention of --mention-on, such as
+// label:security and @security-team, into the labels and the mention.
+func parseMentionOn(condition, mention string) ([]string, string, error) {
+	if !strings.HasPrefix(condition, "label:") {
+		return nil, "", fmt.Errorf("conditions must be given as label:<name>")
+	}
+	var labels []string
+	for _, label := range strings.Split(strings.TrimPrefix(condition, "label:"), ",") {
+		if label = strings.ToLower(strings.TrimSpace(label)); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) == 0 {
+		return nil, "", fmt.Errorf("a label is required")
+	}
+	if len(mention) < 2 || !strings.HasPrefix(mention, "@") || strings.ContainsAny(mention, " \t") {
+		return nil, "", fmt.Errorf("the mention must start with @")
+	}
+	return labels, mention, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLogins parses a comma separated list of GitHub logins, such as the
+// value of the --authors subscribe option, into lower case logins.
+func parseLogins(value string) []string {
+	var logins []string
+	for _, login := range strings.Split(value, ",") {
+		if login = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(login), "@")); login != "" {
+			logins = append(logins, login)
+		}
+	}
+	return logins
+}
+
+// parseTeams parses a comma separated list of team names.
+func parseTeams(value string) []string {
+	var teams []string
+	for _, team := range strings.Split(value, ",") {
+		if team = strings.ToLower(strings.TrimSpace(team)); team != "" {
+			teams = append(teams, team)
+		}
+	}
+	return teams
+}
+
+// parseBranchPatterns parses a comma separated list of branch patterns.
+func parseBranchPatterns(value string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%v is not a valid branch pattern", pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// parseFormat parses the value of the --format subscribe option.
+func parseFormat(value string) (string, error) {
+	switch format := strings.ToLower(strings.TrimSpace(value)); format {
+	case "":
+		return "", nil
+	case FORMAT_DETAILED, FORMAT_COMPACT:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown format %v, use %v or %v", value, FORMAT_COMPACT, FORMAT_DETAILED)
+	}
+}
+
+// parseEvents parses a comma separated list of event names, such as the value
+// of the --events subscribe option.
+func parseEvents(value string) ([]string, error) {
+	var events []string
+	for _, event := range strings.Split(value, ",") {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if event == "" {
+			continue
+		}
+		if !isKnownEvent(event) {
+			return nil, fmt.Errorf("unknown event %v, expected one of %v", event, strings.Join(knownEvents, ", "))
+		}
+		events = append(events, event)
+	}
+	return events, nil
 }
 
-func (s *Subscriptions) GetChannelsForRepository(repository string) []string {
-	fmt.Println("rep:")
-	fmt.Println(s.Repositories)
-	fmt.Println(s.Repositories[repository])
-	return s.Repositories[repository]
+func isKnownEvent(event string) bool {
+	for _, known := range knownEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *Subscriptions) Add(channelId string, repository string) {
+type Subscriptions struct {
+	Repositories map[string][]*Subscription
+
+	// MutedChannels holds the channels that keep their subscriptions but
+	// currently receive no posts.
+	MutedChannels map[string]bool
+
+	// OrgChannels routes the orgRouteEvents of an organization's repositories that
+	// no channel is explicitly subscribed to, keyed by lower case org.
+	OrgChannels map[string]string `json:",omitempty"`
+
+	// scope holds the channels loaded from the KV store, which are the ones
+	// StoreInKVStore writes back. It is nil when every channel was loaded.
+	scope map[string]bool
+
+	// loadedOrgChannels holds the routes as loaded, so that StoreInKVStore
+	// only writes back those that changed.
+	loadedOrgChannels map[string]string
+}
+
+// normalizeRepository converts a repository name into the owner/repo form used
+// as a key in Repositories. GitHub names are case insensitive, so "Owner/Repo"
+// and "owner/repo" refer to the same repository, and a pasted repository URL
+// refers to it as well.
+func normalizeRepository(repository string) string {
+	repository = strings.ToLower(strings.TrimSpace(repository))
+	repository = strings.TrimPrefix(repository, "https://")
+	repository = strings.TrimPrefix(repository, "http://")
+	repository = strings.TrimPrefix(repository, "github.com/")
+	return strings.Trim(repository, "/")
+}
+
+// GetSubscriptionsForRepository returns only the subscriptions of channels
+// explicitly subscribed to the exact repository given.
+func (s *Subscriptions) GetSubscriptionsForRepository(repository string) []*Subscription {
+	return s.Repositories[normalizeRepository(repository)]
+}
+
+// GetSubscriptionsForEvent returns the subscriptions to the repository that
+// want the event posted and whose channel isn't muted. Subscriptions to every
+// repository of the owner, stored as owner/*, count as subscriptions to the
+// repository for channels that aren't subscribed to it explicitly.
+func (s *Subscriptions) GetSubscriptionsForEvent(repository, event string) []*Subscription {
+	// Copied, so that adding wildcard subscriptions leaves the stored ones be.
+	repositorySubscriptions := append([]*Subscription{}, s.GetSubscriptionsForRepository(repository)...)
+	owner := strings.SplitN(normalizeRepository(repository), "/", 2)[0]
+	for _, wildcard := range s.Repositories[owner+"/*"] {
+		explicit := false
+		for _, subscription := range repositorySubscriptions {
+			if subscription.ChannelId == wildcard.ChannelId {
+				explicit = true
+				break
+			}
+		}
+		if !explicit {
+			repositorySubscriptions = append(repositorySubscriptions, wildcard)
+		}
+	}
+	if len(repositorySubscriptions) == 0 {
+		if subscription := s.getOrgSubscription(repository); subscription != nil {
+			repositorySubscriptions = []*Subscription{subscription}
+		}
+	}
+
+	var subscriptions []*Subscription
+	for _, subscription := range repositorySubscriptions {
+		if subscription.HasEvent(event) && !s.IsMuted(subscription.ChannelId) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions
+}
+
+// normalize re-keys subscriptions stored before repository names were
+// normalized, merging channels whose keys differed only by case, and drops
+// the duplicate subscriptions Add used to store for a channel. Keys are merged
+// in sorted order, so that when a channel is subscribed under several of them
+// the subscription kept is always that of the last one.
+func (s *Subscriptions) normalize() {
+	repositories := s.Repositories
+	keys := make([]string, 0, len(repositories))
+	for repository := range repositories {
+		keys = append(keys, repository)
+	}
+	sort.Strings(keys)
+
+	s.Repositories = nil
+	for _, repository := range keys {
+		for _, subscription := range repositories[repository] {
+			if subscription != nil {
+				s.Add(repository, subscription)
+			}
+		}
+	}
+}
+
+// Add subscribes the channel to the repository. If the channel was already
+// subscribed, its subscription is replaced and Add returns false.
+func (s *Subscriptions) Add(repository string, subscription *Subscription) bool {
 	if s.Repositories == nil {
-		s.Repositories = make(map[string][]string)
+		s.Repositories = make(map[string][]*Subscription)
 	}
-	if value, ok := s.Repositories[repository]; ok {
-		value = append(value, channelId)
-		s.Repositories[repository] = value
-	} else {
-		s.Repositories[repository] = []string{channelId}
+	repository = normalizeRepository(repository)
+	for i, existing := range s.Repositories[repository] {
+		if existing.ChannelId == subscription.ChannelId {
+			s.Repositories[repository][i] = subscription
+			return false
+		}
 	}
+	s.Repositories[repository] = append(s.Repositories[repository], subscription)
+	return true
 }
 
-func (s *Subscriptions) Remove(channelId string, repository string) {
+// Remove unsubscribes the channel from the repository. It returns false if the
+// channel was not subscribed.
+func (s *Subscriptions) Remove(channelId string, repository string) bool {
+	repository = normalizeRepository(repository)
+	subscriptions := s.Repositories[repository]
+	for i, subscription := range subscriptions {
+		if subscription.ChannelId != channelId {
+			continue
+		}
+		subscriptions = append(subscriptions[:i], subscriptions[i+1:]...)
+		if len(subscriptions) == 0 {
+			delete(s.Repositories, repository)
+		} else {
+			s.Repositories[repository] = subscriptions
+		}
+		return true
+	}
+	return false
+}
+
+// Rename moves the subscriptions of a repository to its new name. It returns
+// false if nothing was subscribed to the old name.
+func (s *Subscriptions) Rename(from, to string) bool {
+	from = normalizeRepository(from)
+	subscriptions, ok := s.Repositories[from]
+	if !ok || from == normalizeRepository(to) {
+		return false
+	}
+
+	delete(s.Repositories, from)
+	for _, subscription := range subscriptions {
+		s.Add(to, subscription)
+	}
+	return true
 }
 
 func (s *Subscriptions) RemoveAll(channelId string, repository string) {
 }
+
+// getOrgSubscription returns a subscription to the orgRouteEvents for the
+// channel the repository's organization is routed to, if any.
+func (s *Subscriptions) getOrgSubscription(repository string) *Subscription {
+	org := strings.SplitN(normalizeRepository(repository), "/", 2)[0]
+	channelId, ok := s.OrgChannels[org]
+	if !ok {
+		return nil
+	}
+	return &Subscription{ChannelId: channelId, Events: orgRouteEvents}
+}
+
+// RouteOrg makes the channel the default for the organization's repositories.
+func (s *Subscriptions) RouteOrg(org, channelId string) {
+	if s.OrgChannels == nil {
+		s.OrgChannels = make(map[string]string)
+	}
+	s.OrgChannels[strings.ToLower(org)] = channelId
+}
+
+// UnrouteOrg removes the organization's default channel. It returns false if
+// it had none.
+func (s *Subscriptions) UnrouteOrg(org string) bool {
+	org = strings.ToLower(org)
+	if _, ok := s.OrgChannels[org]; !ok {
+		return false
+	}
+	delete(s.OrgChannels, org)
+	return true
+}
+
+func (s *Subscriptions) Mute(channelId string) {
+	if s.MutedChannels == nil {
+		s.MutedChannels = make(map[string]bool)
+	}
+	s.MutedChannels[channelId] = true
+}
+
+func (s *Subscriptions) Unmute(channelId string) {
+	delete(s.MutedChannels, channelId)
+}
+
+func (s *Subscriptions) IsMuted(channelId string) bool {
+	return s.MutedChannels[channelId]
+}
+
+// Validate checks subscriptions that come from outside the plugin, such as an
+// imported backup, before they are stored.
+func (s *Subscriptions) Validate() error {
+	for repository, subscriptions := range s.Repositories {
+		if parts := strings.Split(repository, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("The repository %q is not named owner/repo", repository)
+		}
+		for _, subscription := range subscriptions {
+			if subscription.ChannelId == "" {
+				return fmt.Errorf("A subscription to %v has no channel", repository)
+			}
+			for _, event := range subscription.Events {
+				if !isKnownEvent(event) {
+					return fmt.Errorf("A subscription to %v has the unknown event %q", repository, event)
+				}
+			}
+			if _, err := parseFormat(subscription.Format); err != nil {
+				return fmt.Errorf("A subscription to %v has the unknown format %q", repository, subscription.Format)
+			}
+			if subscription.Mention != "" || len(subscription.MentionLabels) > 0 {
+				if _, _, err := parseMentionOn("label:"+strings.Join(subscription.MentionLabels, ","), subscription.Mention); err != nil {
+					return fmt.Errorf("A subscription to %v has an invalid mention: %v", repository, err.Error())
+				}
+			}
+			if _, err := parseDigest(subscription.Digest); err != nil {
+				return fmt.Errorf("A subscription to %v has the unknown digest %q", repository, subscription.Digest)
+			}
+			if _, err := parseBranchPatterns(strings.Join(subscription.Bases, ",")); err != nil {
+				return fmt.Errorf("A subscription to %v has an invalid base: %v", repository, err.Error())
+			}
+		}
+	}
+	for org, channelId := range s.OrgChannels {
+		if org == "" || strings.Contains(org, "/") || channelId == "" {
+			return fmt.Errorf("The route of %q is not an organization and a channel", org)
+		}
+	}
+	return nil
+}
+
+// Merge adds the other subscriptions to these. A channel's subscription to a
+// repository in other replaces the one it had, and other's mutes and routes
+// are kept alongside the existing ones.
+func (s *Subscriptions) Merge(other *Subscriptions) {
+	for repository, subscriptions := range other.Repositories {
+		for _, subscription := range subscriptions {
+			s.Add(repository, subscription)
+		}
+	}
+	for channelId, muted := range other.MutedChannels {
+		if muted {
+			s.Mute(channelId)
+		}
+	}
+	for org, channelId := range other.OrgChannels {
+		s.RouteOrg(org, channelId)
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const (
+	// SUBSCRIPTIONS_KEY held every subscription in a single value before they
+	// were stored per channel. It is only read to migrate them, and is left
+	// in place afterwards.
+	SUBSCRIPTIONS_KEY = "subscriptions"
+
+	SUBSCRIPTIONS_INDEX_KEY             = "subscriptions_index"
+	CHANNEL_SUBSCRIPTIONS_KEY_PREFIX    = "subs_"
+	REPOSITORY_SUBSCRIPTIONS_KEY_PREFIX = "subs_repo_"
+)
+
+// subscriptionsIndexLock serializes the writes of the index and of the
+// channel lists of repositories, which are read, changed and written back.
+var subscriptionsIndexLock sync.Mutex
+
+// channelSubscriptions is what is stored for each channel, under
+// CHANNEL_SUBSCRIPTIONS_KEY_PREFIX and its id.
+type channelSubscriptions struct {
+	Repositories map[string]*Subscription
+	Muted        bool `json:",omitempty"`
+}
+
+// subscriptionsIndex holds what can't be found through a repository. The
+// channels subscribed to each repository are stored under the repository's
+// own key, so that a change to a channel only rewrites the repositories it
+// touches.
+type subscriptionsIndex struct {
+	// Channels holds every channel with stored subscriptions.
+	Channels map[string]bool
+
+	OrgChannels map[string]string `json:",omitempty"`
+}
+
+// repositorySubscriptionsKey returns the key listing the channels subscribed
+// to the repository. The name is hashed, since it may be longer than a key.
+func repositorySubscriptionsKey(repository string) string {
+	hash := md5.Sum([]byte(repository))
+	return REPOSITORY_SUBSCRIPTIONS_KEY_PREFIX + hex.EncodeToString(hash[:])
+}
+
+// NewSubscriptionsFromKVStore loads the subscriptions of every channel. Prefer
+// the narrower loaders for work that concerns a channel or a repository.
+func NewSubscriptionsFromKVStore(store plugin.KeyValueStore) (*Subscriptions, error) {
+	index, err := loadSubscriptionsIndex(store)
+	if err != nil {
+		return nil, err
+	}
+
+	var channelIds []string
+	for channelId := range index.Channels {
+		channelIds = append(channelIds, channelId)
+	}
+	subscriptions, err := loadChannels(store, index, channelIds)
+	if err != nil {
+		return nil, err
+	}
+	subscriptions.scope = nil
+	return subscriptions, nil
+}
+
+// NewSubscriptionsForChannelFromKVStore loads the subscriptions of the channel
+// and the organization routes.
+func NewSubscriptionsForChannelFromKVStore(store plugin.KeyValueStore, channelId string) (*Subscriptions, error) {
+	index, err := loadSubscriptionsIndex(store)
+	if err != nil {
+		return nil, err
+	}
+	return loadChannels(store, index, []string{channelId})
+}
+
+// NewSubscriptionsForRepositoryFromKVStore loads the subscriptions of every
+// channel that gets the repository's events, whether explicitly, through a
+// subscription to all of its owner's repositories or through an org route.
+func NewSubscriptionsForRepositoryFromKVStore(store plugin.KeyValueStore, repository string) (*Subscriptions, error) {
+	index, err := loadSubscriptionsIndex(store)
+	if err != nil {
+		return nil, err
+	}
+
+	repository = normalizeRepository(repository)
+	owner := strings.SplitN(repository, "/", 2)[0]
+	var channelIds []string
+	for _, key := range []string{repository, owner + "/*"} {
+		subscribed, err := loadRepositoryChannels(store, key)
+		if err != nil {
+			return nil, err
+		}
+		channelIds = append(channelIds, subscribed...)
+	}
+	if channelId, ok := index.OrgChannels[owner]; ok {
+		channelIds = append(channelIds, channelId)
+	}
+	return loadChannels(store, index, channelIds)
+}
+
+// StoreInKVStore writes back the channels that were loaded, along with any
+// that were added, and updates the channel lists of the repositories they
+// were or are now subscribed to. Other channels are left as stored, even if
+// they changed since these subscriptions were loaded.
+func (s *Subscriptions) StoreInKVStore(store plugin.KeyValueStore) error {
+	subscriptionsIndexLock.Lock()
+	defer subscriptionsIndexLock.Unlock()
+
+	index, err := readSubscriptionsIndex(store)
+	if err != nil {
+		return err
+	}
+	return s.store(store, index)
+}
+
+// store writes the subscriptions into the index read under
+// subscriptionsIndexLock.
+func (s *Subscriptions) store(store plugin.KeyValueStore, index *subscriptionsIndex) error {
+	entries := map[string]*channelSubscriptions{}
+	entry := func(channelId string) *channelSubscriptions {
+		if entries[channelId] == nil {
+			entries[channelId] = &channelSubscriptions{Repositories: map[string]*Subscription{}}
+		}
+		return entries[channelId]
+	}
+	for repository, subscriptions := range s.Repositories {
+		for _, subscription := range subscriptions {
+			entry(subscription.ChannelId).Repositories[repository] = subscription
+		}
+	}
+	for channelId, muted := range s.MutedChannels {
+		if muted {
+			entry(channelId).Muted = true
+		}
+	}
+
+	// Without a scope every channel was loaded, so every stored one is
+	// written back as well.
+	scope := map[string]bool{}
+	for channelId := range s.scope {
+		scope[channelId] = true
+	}
+	if s.scope == nil {
+		for channelId := range index.Channels {
+			scope[channelId] = true
+		}
+	}
+	for channelId := range entries {
+		scope[channelId] = true
+	}
+
+	// Each channel leaves the repositories it was stored with and joins those
+	// it has now, which are the only repositories rewritten.
+	indexChanged := false
+	repositoryChanges := map[string]map[string]bool{}
+	change := func(repository, channelId string, subscribed bool) {
+		if repositoryChanges[repository] == nil {
+			repositoryChanges[repository] = map[string]bool{}
+		}
+		repositoryChanges[repository][channelId] = subscribed
+	}
+	for channelId := range scope {
+		stored, err := loadChannelEntry(store, channelId)
+		if err != nil {
+			return err
+		}
+		if stored != nil {
+			for repository := range stored.Repositories {
+				change(repository, channelId, false)
+			}
+		}
+
+		channelEntry, ok := entries[channelId]
+		if !ok {
+			if stored != nil {
+				if err := store.Delete(CHANNEL_SUBSCRIPTIONS_KEY_PREFIX + channelId); err != nil {
+					return err
+				}
+			}
+			if index.Channels[channelId] {
+				delete(index.Channels, channelId)
+				indexChanged = true
+			}
+			continue
+		}
+
+		b, err := json.Marshal(channelEntry)
+		if err != nil {
+			return err
+		}
+		if err := store.Set(CHANNEL_SUBSCRIPTIONS_KEY_PREFIX+channelId, b); err != nil {
+			return err
+		}
+		if !index.Channels[channelId] {
+			index.Channels[channelId] = true
+			indexChanged = true
+		}
+		for repository := range channelEntry.Repositories {
+			change(repository, channelId, true)
+		}
+	}
+
+	for repository, changes := range repositoryChanges {
+		stored, err := loadRepositoryChannels(store, repository)
+		if err != nil {
+			return err
+		}
+		channelIds := []string{}
+		for _, channelId := range stored {
+			if _, ok := changes[channelId]; !ok {
+				channelIds = append(channelIds, channelId)
+			}
+		}
+		for channelId, subscribed := range changes {
+			if subscribed {
+				channelIds = append(channelIds, channelId)
+			}
+		}
+		sort.Strings(channelIds)
+		if err := storeRepositoryChannels(store, repository, stored, channelIds); err != nil {
+			return err
+		}
+	}
+
+	// Only the routes changed since loading are applied, so that those
+	// changed meanwhile through other subscriptions are kept.
+	for org, channelId := range s.OrgChannels {
+		if s.loadedOrgChannels[org] != channelId {
+			if index.OrgChannels == nil {
+				index.OrgChannels = map[string]string{}
+			}
+			index.OrgChannels[org] = channelId
+			indexChanged = true
+		}
+	}
+	for org := range s.loadedOrgChannels {
+		if _, ok := s.OrgChannels[org]; !ok {
+			delete(index.OrgChannels, org)
+			indexChanged = true
+		}
+	}
+
+	if !indexChanged {
+		return nil
+	}
+	return storeSubscriptionsIndex(store, index)
+}
+
+func storeSubscriptionsIndex(store plugin.KeyValueStore, index *subscriptionsIndex) error {
+	b, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := store.Set(SUBSCRIPTIONS_INDEX_KEY, b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadSubscriptionsIndex loads the index, first migrating subscriptions stored
+// under SUBSCRIPTIONS_KEY if there is none yet.
+func loadSubscriptionsIndex(store plugin.KeyValueStore) (*subscriptionsIndex, error) {
+	subscriptionsIndexLock.Lock()
+	defer subscriptionsIndexLock.Unlock()
+	return readSubscriptionsIndex(store)
+}
+
+// readSubscriptionsIndex is loadSubscriptionsIndex for callers holding
+// subscriptionsIndexLock.
+func readSubscriptionsIndex(store plugin.KeyValueStore) (*subscriptionsIndex, error) {
+	index := &subscriptionsIndex{}
+	value, err := store.Get(SUBSCRIPTIONS_INDEX_KEY)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		if err := json.Unmarshal(value, index); err != nil {
+			return nil, err
+		}
+	}
+	if index.Channels == nil {
+		index.Channels = map[string]bool{}
+	}
+	if value != nil {
+		return index, nil
+	}
+
+	legacy, err := store.Get(SUBSCRIPTIONS_KEY)
+	if err != nil {
+		return nil, err
+	}
+	var subscriptions Subscriptions
+	if legacy != nil {
+		json.NewDecoder(bytes.NewReader(legacy)).Decode(&subscriptions)
+		subscriptions.normalize()
+	}
+	if err := subscriptions.store(store, index); err != nil {
+		return nil, err
+	}
+	// Stored even without any subscriptions, so that the migration only runs
+	// once.
+	if err := storeSubscriptionsIndex(store, index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// loadChannelEntry loads what is stored for the channel, or nil if nothing is.
+func loadChannelEntry(store plugin.KeyValueStore, channelId string) (*channelSubscriptions, error) {
+	value, err := store.Get(CHANNEL_SUBSCRIPTIONS_KEY_PREFIX + channelId)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	var entry channelSubscriptions
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// loadRepositoryChannels loads the channels subscribed to the normalized
+// repository.
+func loadRepositoryChannels(store plugin.KeyValueStore, repository string) ([]string, error) {
+	value, err := store.Get(repositorySubscriptionsKey(repository))
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	var channelIds []string
+	if err := json.Unmarshal(value, &channelIds); err != nil {
+		return nil, err
+	}
+	return channelIds, nil
+}
+
+// storeRepositoryChannels replaces the stored channels of the repository, if
+// they changed.
+func storeRepositoryChannels(store plugin.KeyValueStore, repository string, stored, channelIds []string) error {
+	if len(stored) == 0 && len(channelIds) == 0 || reflect.DeepEqual(stored, channelIds) {
+		return nil
+	}
+	if len(channelIds) == 0 {
+		if err := store.Delete(repositorySubscriptionsKey(repository)); err != nil {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(channelIds)
+	if err != nil {
+		return err
+	}
+	if err := store.Set(repositorySubscriptionsKey(repository), b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadChannels loads the subscriptions of the channels along with the org
+// routes. The result's scope is those channels.
+func loadChannels(store plugin.KeyValueStore, index *subscriptionsIndex, channelIds []string) (*Subscriptions, error) {
+	subscriptions := &Subscriptions{
+		OrgChannels:       map[string]string{},
+		loadedOrgChannels: map[string]string{},
+		scope:             map[string]bool{},
+	}
+	for org, channelId := range index.OrgChannels {
+		subscriptions.OrgChannels[org] = channelId
+		subscriptions.loadedOrgChannels[org] = channelId
+	}
+	for _, channelId := range channelIds {
+		if subscriptions.scope[channelId] {
+			continue
+		}
+		subscriptions.scope[channelId] = true
+
+		entry, err := loadChannelEntry(store, channelId)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		for repository, subscription := range entry.Repositories {
+			if subscription == nil {
+				continue
+			}
+			subscription.ChannelId = channelId
+			subscriptions.Add(repository, subscription)
+		}
+		if entry.Muted {
+			subscriptions.Mute(channelId)
+		}
+	}
+	return subscriptions, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// subscribedChannels returns the channels whose events for the repository are
+// loaded, sorted.
+func subscribedChannels(t *testing.T, api *testAPI, repository string) []string {
+	subscriptions, err := NewSubscriptionsForRepositoryFromKVStore(api.KeyValueStore(), repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channelIds := []string{}
+	for _, subscription := range subscriptions.GetSubscriptionsForEvent(repository, EVENT_PULLS) {
+		channelIds = append(channelIds, subscription.ChannelId)
+	}
+	sort.Strings(channelIds)
+	return channelIds
+}
+
+func TestInterleavedChannelStoresKeepEachOthersChanges(t *testing.T) {
+	for _, bFirst := range []bool{false, true} {
+		api := &testAPI{}
+		store := api.KeyValueStore()
+		subscribeForTest(t, &Plugin{api: api}, "owner/old", &Subscription{ChannelId: "channel-a"})
+		subscribeForTest(t, &Plugin{api: api}, "owner/old", &Subscription{ChannelId: "channel-b"})
+
+		// Both channels are loaded before either is stored, as when two
+		// commands run at once.
+		a, err := NewSubscriptionsForChannelFromKVStore(store, "channel-a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := NewSubscriptionsForChannelFromKVStore(store, "channel-b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Add("owner/x", &Subscription{ChannelId: "channel-a"})
+		a.Add("owner/y", &Subscription{ChannelId: "channel-a"})
+		a.Remove("channel-a", "owner/old")
+		b.Add("owner/x", &Subscription{ChannelId: "channel-b"})
+		b.RouteOrg("other", "channel-b")
+
+		stores := []*Subscriptions{a, b}
+		if bFirst {
+			stores = []*Subscriptions{b, a}
+		}
+		for _, subscriptions := range stores {
+			if err := subscriptions.StoreInKVStore(store); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, tc := range []struct {
+			repository string
+			channels   []string
+		}{
+			{"owner/x", []string{"channel-a", "channel-b"}},
+			{"owner/y", []string{"channel-a"}},
+			{"owner/old", []string{"channel-b"}},
+			{"other/z", []string{"channel-b"}},
+		} {
+			if channels := subscribedChannels(t, api, tc.repository); !reflect.DeepEqual(channels, tc.channels) {
+				t.Errorf("storing channel-b first %v: %v goes to %v, want %v", bFirst, tc.repository, channels, tc.channels)
+			}
+		}
+
+		all, err := NewSubscriptionsFromKVStore(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subscriptions := all.GetSubscriptionsForRepository("owner/x"); len(subscriptions) != 2 {
+			t.Errorf("storing channel-b first %v: loaded %v subscriptions to owner/x, want 2", bFirst, len(subscriptions))
+		}
+	}
+}
+
+func TestConcurrentChannelStoresKeepEachOthersChanges(t *testing.T) {
+	api := &testAPI{}
+	var want []string
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		channelId := fmt.Sprintf("channel-%02d", i)
+		want = append(want, channelId)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			subscriptions, err := NewSubscriptionsForChannelFromKVStore(api.KeyValueStore(), channelId)
+			if err == nil {
+				subscriptions.Add("owner/x", &Subscription{ChannelId: channelId})
+				err = subscriptions.StoreInKVStore(api.KeyValueStore())
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if channels := subscribedChannels(t, api, "owner/x"); !reflect.DeepEqual(channels, want) {
+		t.Errorf("owner/x goes to %v, want %v", channels, want)
+	}
+}
+
+func TestUnsubscribingRemovesTheRepositoryChannelList(t *testing.T) {
+	api := &testAPI{}
+	store := api.KeyValueStore()
+	subscribeForTest(t, &Plugin{api: api}, "owner/x", &Subscription{ChannelId: "channel-a"})
+
+	subscriptions, err := NewSubscriptionsForChannelFromKVStore(store, "channel-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriptions.Remove("channel-a", "owner/x")
+	if err := subscriptions.StoreInKVStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{repositorySubscriptionsKey("owner/x"), CHANNEL_SUBSCRIPTIONS_KEY_PREFIX + "channel-a"} {
+		if value, _ := store.Get(key); value != nil {
+			t.Errorf("%v is still stored as %s", key, value)
+		}
+	}
+}
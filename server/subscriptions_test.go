@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSubscribingTwiceReplacesTheSubscription(t *testing.T) {
+	for _, tc := range []struct {
+		first, second string
+	}{
+		{"owner/x", "owner/x"},
+		{"owner/x", "Owner/X"},
+		{"owner/x", "https://github.com/owner/x/"},
+		{"owner/*", "Owner/*"},
+	} {
+		api := &testAPI{}
+		store := api.KeyValueStore()
+		for i, repository := range []string{tc.first, tc.second} {
+			subscriptions, err := NewSubscriptionsForChannelFromKVStore(store, "channel-a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			added := subscriptions.Add(repository, &Subscription{ChannelId: "channel-a", Format: repository})
+			if added != (i == 0) {
+				t.Errorf("%v then %v: subscribing to %v added is %v", tc.first, tc.second, repository, added)
+			}
+			if err := subscriptions.StoreInKVStore(store); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		subscriptions, err := NewSubscriptionsForChannelFromKVStore(store, "channel-a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := subscriptions.GetSubscriptionsForRepository(tc.first)
+		if len(stored) != 1 {
+			t.Errorf("%v then %v: stored %v subscriptions, want 1", tc.first, tc.second, len(stored))
+		} else if stored[0].Format != tc.second {
+			t.Errorf("%v then %v: kept the subscription to %v", tc.first, tc.second, stored[0].Format)
+		}
+	}
+}
+
+func TestNormalizeMergesDuplicateSubscriptions(t *testing.T) {
+	// Maps are iterated in a random order, so normalize runs several times.
+	for i := 0; i < 20; i++ {
+		subscriptions := Subscriptions{Repositories: map[string][]*Subscription{
+			"Owner/X": {{ChannelId: "channel-a", Format: "Owner/X"}},
+			"owner/x": {
+				{ChannelId: "channel-a", Format: "owner/x"},
+				{ChannelId: "channel-b"},
+				{ChannelId: "channel-b"},
+				nil,
+			},
+			"OWNER/x": {{ChannelId: "channel-a", Format: "OWNER/x"}},
+		}}
+
+		subscriptions.normalize()
+
+		if len(subscriptions.Repositories) != 1 {
+			t.Fatalf("kept the keys %v", subscriptions.Repositories)
+		}
+		stored := subscriptions.GetSubscriptionsForRepository("owner/x")
+		if len(stored) != 2 {
+			t.Fatalf("kept %v subscriptions, want 2", len(stored))
+		}
+		// owner/x sorts last, so its subscription of channel-a is kept.
+		if stored[0].ChannelId != "channel-a" || stored[0].Format != "owner/x" {
+			t.Errorf("kept the subscription of %v to %v", stored[0].ChannelId, stored[0].Format)
+		}
+		if stored[1].ChannelId != "channel-b" {
+			t.Errorf("kept the subscription of %v", stored[1].ChannelId)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// ETAG_CACHE_SIZE bounds the number of GitHub responses kept for
+	// conditional requests.
+	ETAG_CACHE_SIZE = 1000
+
+	// MAX_RETRY_AFTER is the longest GitHub's Retry-After is honored before
+	// giving up and returning its response.
+	MAX_RETRY_AFTER = time.Minute
+)
+
+// githubResponseCache is shared by every GitHub client the plugin creates.
+var githubResponseCache = newETagCache(ETAG_CACHE_SIZE)
+
+// githubBaseTransport carries every GitHub request the plugin makes. It is
+// replaced when a proxy or CA certificate is configured.
+var githubBaseTransport = &baseTransport{transport: http.DefaultTransport}
+
+type baseTransport struct {
+	lock      sync.RWMutex
+	transport http.RoundTripper
+}
+
+func (t *baseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.RLock()
+	transport := t.transport
+	t.lock.RUnlock()
+	return transport.RoundTrip(req)
+}
+
+func (t *baseTransport) set(transport http.RoundTripper) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.transport = transport
+}
+
+// newBaseTransport builds the transport for the configured proxy and CA
+// certificate, or returns the default transport if neither is set.
+func newBaseTransport(config *Configuration) (http.RoundTripper, error) {
+	if config.ProxyURL == "" && config.CACertPath == "" {
+		return http.DefaultTransport, nil
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.CACertPath != "" {
+		pem, err := ioutil.ReadFile(config.CACertPath)
+		if err != nil {
+			return nil, err
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", config.CACertPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	return transport, nil
+}
+
+type etagCacheEntry struct {
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// etagCache remembers GitHub responses by token and URL so that repeated
+// requests can be made conditional with If-None-Match. The oldest entries are
+// dropped once it is full.
+type etagCache struct {
+	lock       sync.Mutex
+	entries    map[string]*etagCacheEntry
+	order      []string
+	maxEntries int
+}
+
+func newETagCache(maxEntries int) *etagCache {
+	return &etagCache{
+		entries:    make(map[string]*etagCacheEntry),
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *etagCache) get(key string) *etagCacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.entries[key]
+}
+
+func (c *etagCache) set(key string, entry *etagCacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = entry
+
+	for len(c.order) > c.maxEntries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// githubTransport makes GET requests conditional on the ETag of the last
+// response seen for them, answering 304s from the cache, and waits out a
+// Retry-After on 403 and 429 responses before retrying once. It belongs
+// beneath the oauth2 transport so that cached responses are never shared
+// between tokens.
+type githubTransport struct {
+	base  http.RoundTripper
+	cache *etagCache
+}
+
+func (t *githubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	key := req.Header.Get("Authorization") + " " + req.URL.String()
+	cached := t.cache.get(key)
+	if cached != nil {
+		req = cloneRequest(req)
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if wait, ok := retryAfter(resp); ok {
+		resp.Body.Close()
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if resp, err = t.base.RoundTrip(req); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		resp.Body.Close()
+		header := http.Header{}
+		for name, values := range cached.header {
+			header[name] = values
+		}
+		for name, values := range resp.Header {
+			header[name] = values
+		}
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Header = header
+		resp.Body = ioutil.NopCloser(bytes.NewReader(cached.body))
+		resp.ContentLength = int64(len(cached.body))
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.cache.set(key, &etagCacheEntry{etag: resp.Header.Get("ETag"), header: resp.Header, body: body})
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	return resp, nil
+}
+
+// retryAfter returns how long GitHub asked to wait before retrying, if the
+// response is a 403 or 429 with a Retry-After short enough to honor.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	wait := time.Duration(seconds) * time.Second
+	if wait > MAX_RETRY_AFTER {
+		return 0, false
+	}
+	return wait, true
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for name, values := range req.Header {
+		clone.Header[name] = append([]string(nil), values...)
+	}
+	return clone
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// errNoUserToken is returned by getUserToken for users who haven't registered
+// a token. It explains how to connect and is meant to be shown as is.
+var errNoUserToken = errors.New("You haven't connected your GitHub account yet. Create a personal access token at https://github.com/settings/tokens and run `/github register <token>` to connect it.")
+
+// getUserToken returns the GitHub token the user registered, or errNoUserToken
+// if they haven't.
+func (p *Plugin) getUserToken(userId string) (string, error) {
+	b, err := p.api.KeyValueStore().Get(userId + GITHUB_TOKEN_KEY)
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", errNoUserToken
+	}
+	return string(b), nil
+}
+
+// describeUserTokenError explains why the user's token couldn't be used,
+// showing errNoUserToken without the prefix.
+func describeUserTokenError(prefix string, err error) string {
+	if err == errNoUserToken {
+		return err.Error()
+	}
+	return prefix + ": " + err.Error()
+}
+
+// deleteUserToken forgets the user's GitHub token along with everything
+// recorded about the account it belongs to.
+func (p *Plugin) deleteUserToken(userId string) {
+	p.api.KeyValueStore().Delete(userId + GITHUB_TOKEN_KEY)
+	p.deleteGitHubLogin(userId)
+	p.api.KeyValueStore().Delete(userId + GITHUB_USERINFO_KEY)
+}
+
+// handleGitHubAuthError checks whether err is GitHub rejecting the user's
+// token, in which case the token is deleted and the user is asked to register
+// a new one. It returns whether err was handled.
+func (p *Plugin) handleGitHubAuthError(userId string, err error) bool {
+	errResp, ok := err.(*github.ErrorResponse)
+	if !ok || errResp.Response == nil || errResp.Response.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+
+	p.deleteUserToken(userId)
+	p.sendDirectMessage(userId, "GitHub rejected your token, it may have expired or been revoked. Use `/github register <token>` to reconnect your account.")
+	return true
+}
+
+// GitHubUserInfo records when a user registered their GitHub token. It is kept
+// apart from the token itself, and the account's login is kept by
+// storeGitHubLogin.
+type GitHubUserInfo struct {
+	ConnectedAt int64 `json:"connected_at"`
+}
+
+func (p *Plugin) storeGitHubUserInfo(userId string, info *GitHubUserInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if appErr := p.api.KeyValueStore().Set(userId+GITHUB_USERINFO_KEY, b); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// getGitHubUserInfo returns the connection details the user registered, or
+// nil if they haven't registered a token.
+func (p *Plugin) getGitHubUserInfo(userId string) *GitHubUserInfo {
+	b, err := p.api.KeyValueStore().Get(userId + GITHUB_USERINFO_KEY)
+	if err != nil || b == nil {
+		return nil
+	}
+
+	var info GitHubUserInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil
+	}
+	return &info
+}
+
+// storeGitHubLogin records which GitHub account a Mattermost user registered,
+// in both directions, so that GitHub activity can be routed back to them.
+func (p *Plugin) storeGitHubLogin(userId, login string) {
+	if login == "" {
+		return
+	}
+
+	p.deleteGitHubLogin(userId)
+	p.api.KeyValueStore().Set(userId+GITHUB_USERNAME_KEY, []byte(login))
+	p.api.KeyValueStore().Set(strings.ToLower(login)+GITHUB_USERID_KEY, []byte(userId))
+}
+
+func (p *Plugin) deleteGitHubLogin(userId string) {
+	login := p.getGitHubLogin(userId)
+	if login == "" {
+		return
+	}
+
+	p.api.KeyValueStore().Delete(userId + GITHUB_USERNAME_KEY)
+	p.api.KeyValueStore().Delete(strings.ToLower(login) + GITHUB_USERID_KEY)
+}
+
+// getGitHubLogin returns the GitHub login the user registered, or an empty
+// string if they haven't.
+func (p *Plugin) getGitHubLogin(userId string) string {
+	b, err := p.api.KeyValueStore().Get(userId + GITHUB_USERNAME_KEY)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// getUserIdForGitHubLogin returns the id of the Mattermost user who registered
+// the GitHub login, or an empty string if nobody has.
+func (p *Plugin) getUserIdForGitHubLogin(login string) string {
+	b, err := p.api.KeyValueStore().Get(strings.ToLower(login) + GITHUB_USERID_KEY)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// getDirectChannel returns the direct channel between the plugin's user and
+// the user, which is where everything the plugin tells a user privately goes.
+func (p *Plugin) getDirectChannel(userId string) (*model.Channel, *model.AppError) {
+	return p.api.GetDirectChannel(p.getUserId(), userId)
+}
+
+// sendDirectMessage posts the message to the user in their direct channel
+// with the plugin's user.
+func (p *Plugin) sendDirectMessage(userId, message string) {
+	channel, err := p.getDirectChannel(userId)
+	if err != nil {
+		fmt.Println("Error getting the direct channel: " + err.Error())
+		return
+	}
+
+	if _, err := p.api.CreatePost(p.newPost(channel.Id, message)); err != nil {
+		fmt.Println("Error sending direct message: " + err.Error())
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDirectMessagesUseTheBotAndUserChannel(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		send func(p *Plugin, userId string)
+	}{
+		{"direct message", func(p *Plugin, userId string) {
+			p.sendDirectMessage(userId, "message")
+		}},
+		{"todo", func(p *Plugin, userId string) {
+			// Without a token, the todo is an error explaining that.
+			p.HandleTodo(context.Background(), userId, nil, 0, nil)
+		}},
+	} {
+		api := &testAPI{}
+		p := newTestPlugin(api)
+
+		tc.send(p, "userid")
+
+		if want := [][2]string{{testBotUserId, "userid"}}; !reflect.DeepEqual(api.directChannels, want) {
+			t.Errorf("%v: opened the direct channels %v, want %v", tc.name, api.directChannels, want)
+		}
+		if len(api.posts) != 1 {
+			t.Fatalf("%v: made %v posts, want 1", tc.name, len(api.posts))
+		}
+		if post := api.posts[0]; post.ChannelId != testBotUserId+"__userid" || post.UserId != testBotUserId {
+			t.Errorf("%v: posted to %v as %v", tc.name, post.ChannelId, post.UserId)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Version, BuildHash and BuildDate are set at build time with -ldflags, see
+// the dist target of the Makefile.
+var (
+	Version   = "dev"
+	BuildHash = "unknown"
+	BuildDate = "unknown"
+)
+
+// The dependency versions the plugin is built against, as pinned in
+// Gopkg.lock. Keep these in step with it when upgrading.
+const (
+	GO_GITHUB_VERSION         = "v15.0.0"
+	MATTERMOST_SERVER_VERSION = "v4.7.2"
+)
+
+// describeVersion describes the running build for /github version.
+func describeVersion() string {
+	return fmt.Sprintf("GitHub plugin **%v**\n* Build: %v, %v\n* Go: %v\n* go-github: %v\n* mattermost-server: %v",
+		Version, BuildHash, BuildDate, runtime.Version(), GO_GITHUB_VERSION, MATTERMOST_SERVER_VERSION)
+}
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// WEBHOOK_TOKEN_KEY holds the webhook path token generated for the install.
+const WEBHOOK_TOKEN_KEY = "webhook_token"
+
+// LEGACY_WEBHOOK_PATH is where deliveries were accepted before the webhook
+// got a path token. Once there is a token, hooks created back then only keep
+// working if AcceptLegacyWebhookPath is set, still subject to the secret check.
+const LEGACY_WEBHOOK_PATH = "/webhook"
+
+// ensureWebhookToken generates the install's webhook path token on first
+// activation. The KV store of this server version can't compare and set, so
+// nodes starting together may each store one. The token is therefore never
+// cached, so that every node serves whichever was stored last.
+func (p *Plugin) ensureWebhookToken() error {
+	token, err := p.api.KeyValueStore().Get(WEBHOOK_TOKEN_KEY)
+	if err != nil {
+		return err
+	}
+	if len(token) > 0 {
+		return nil
+	}
+	if err := p.api.KeyValueStore().Set(WEBHOOK_TOKEN_KEY, []byte(model.NewId())); err != nil {
+		return err
+	}
+	return nil
+}
+
+// webhookURLPath returns the path, relative to the plugin, that GitHub
+// deliveries are accepted on. It is LEGACY_WEBHOOK_PATH only until a token
+// has been generated.
+func (p *Plugin) webhookURLPath() string {
+	if token := p.config().WebhookPathToken; token != "" {
+		return LEGACY_WEBHOOK_PATH + "/" + token
+	}
+	token, err := p.api.KeyValueStore().Get(WEBHOOK_TOKEN_KEY)
+	if err != nil || len(token) == 0 {
+		return LEGACY_WEBHOOK_PATH
+	}
+	return LEGACY_WEBHOOK_PATH + "/" + string(token)
+}
+
+// webhookURL returns the URL GitHub should deliver to, or just its path if
+// the site URL isn't configured. The secret is set on the hook rather than
+// put in the URL, where anyone who can see the hook could read it.
+func (p *Plugin) webhookURL() string {
+	return strings.TrimRight(p.config().SiteURL, "/") + "/plugins/github" + p.webhookURLPath()
+}
+
+func (p *Plugin) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	config := p.config()
+
+	r.Body = http.MaxBytesReader(w, r.Body, config.GetWebhookMaxBodySize())
+	if err := r.ParseForm(); err != nil {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "Bad request body")
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		if isBodyTooLarge(err) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		fmt.Println("Err: " + err.Error())
+	}
+
+	// GitHub signs deliveries with the secret set on the hook, which may
+	// depend on the owner of the repository the delivery is about. Hooks set
+	// up before that carry the secret in their URL instead.
+	owner, ok := webhookOwner(body)
+	authorized := config.IsWebhookSignature(owner, r.Header.Get("X-Hub-Signature"), body) ||
+		config.IsWebhookSecret(owner, r.URL.Query().Get("secret"))
+	if !ok || !authorized {
+		writeJSONError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+	p.metrics.incReceived(github.WebHookType(r))
+	p.logWebhookDebug(github.WebHookType(r), github.DeliveryID(r), body)
+
+	// The payload comes from the internet, so a malformed delivery must not
+	// take the handler down with it.
+	defer func() {
+		if x := recover(); x != nil {
+			fmt.Printf("Recovered from panic handling %v webhook %v: %v\n%s", github.WebHookType(r), github.DeliveryID(r), x, debug.Stack())
+			writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		}
+	}()
+
+	response := WebhookResponse{Event: github.WebHookType(r)}
+
+	// The pinned go-github predates discussions, so ParseWebHook doesn't know
+	// their type. Once it has a DiscussionEvent, this can move into the switch.
+	if github.WebHookType(r) == "discussion" {
+		if !config.IsEventDisabled(EVENT_DISCUSSIONS) {
+			response.Handled = true
+			response.Routed = p.discussionChanged(body)
+		}
+		writeWebhookResponse(w, response)
+		return
+	}
+
+	event, err := github.ParseWebHook(github.WebHookType(r), body)
+	if err != nil {
+		// Event types the plugin doesn't know are acknowledged, so that they
+		// don't show up as failed deliveries on GitHub.
+		switch err.(type) {
+		case *json.SyntaxError, *json.UnmarshalTypeError:
+			writeJSONError(w, http.StatusBadRequest, "Invalid payload")
+			return
+		}
+		writeWebhookResponse(w, response)
+		return
+	}
+
+	switch event := event.(type) {
+	case *github.PullRequestEvent:
+		// Other actions, such as edited or synchronize, would otherwise repost
+		// the pull request as if it had just been opened. Drafts can wait
+		// until they are ready for review.
+		post := event.GetAction() == "opened"
+		if config.SkipDraftPullRequests {
+			post = (post && !isDraftPullRequest(body)) || event.GetAction() == "ready_for_review"
+		}
+		if !config.IsEventDisabled(EVENT_PULLS) && post {
+			response.Handled = true
+			response.Routed = p.pullRequestOpened(event.GetRepo().GetFullName(), event.GetPullRequest())
+		}
+	case *github.PullRequestReviewEvent:
+		if !config.IsEventDisabled(EVENT_REVIEWS) && event.GetAction() == "submitted" {
+			response.Handled = true
+			response.Routed = p.pullRequestReviewed(event)
+		}
+	case *github.CreateEvent:
+		if !config.IsEventDisabled(EVENT_REFS) {
+			response.Handled = true
+			response.Routed = p.refChanged(event.GetRepo(), event.GetSender(), event.GetRefType(), event.GetRef(), true)
+		}
+	case *github.DeleteEvent:
+		if !config.IsEventDisabled(EVENT_REFS) {
+			response.Handled = true
+			response.Routed = p.refChanged(event.GetRepo(), event.GetSender(), event.GetRefType(), event.GetRef(), false)
+		}
+	case *github.IssuesEvent:
+		if !config.IsEventDisabled(EVENT_ISSUES) && (event.GetAction() == "opened" || event.GetAction() == "assigned") {
+			response.Handled = true
+			response.Routed = p.issueChanged(event)
+		}
+	case *github.IssueCommentEvent:
+		if event.GetAction() == "created" {
+			issue := event.GetIssue()
+			title := fmt.Sprintf("%v#%v %v", event.GetRepo().GetFullName(), issue.GetNumber(), issue.GetTitle())
+			response.Handled = true
+			response.Routed = p.commentMentioned(event.GetRepo(), event.GetComment().GetUser().GetLogin(), title, event.GetComment().GetHTMLURL(), event.GetComment().GetBody())
+		}
+	case *github.PullRequestReviewCommentEvent:
+		if event.GetAction() == "created" {
+			pullRequest := event.GetPullRequest()
+			title := fmt.Sprintf("%v#%v %v", event.GetRepo().GetFullName(), pullRequest.GetNumber(), pullRequest.GetTitle())
+			response.Handled = true
+			response.Routed = p.commentMentioned(event.GetRepo(), event.GetComment().GetUser().GetLogin(), title, event.GetComment().GetHTMLURL(), event.GetComment().GetBody())
+		}
+	case *github.RepositoryEvent:
+		response.Handled = true
+		response.Routed = p.repositoryChanged(event, body)
+	case *github.PingEvent:
+		response.Handled = true
+		response.Routed = p.webhookPinged(event, body)
+	}
+	writeWebhookResponse(w, response)
+}
+
+// isDraftPullRequest reports whether the pull request of a pull_request
+// delivery is a draft. The pinned go-github predates drafts, so the payload is
+// read directly.
+func isDraftPullRequest(body []byte) bool {
+	var payload struct {
+		PullRequest struct {
+			Draft bool `json:"draft"`
+		} `json:"pull_request"`
+	}
+	json.Unmarshal(body, &payload)
+	return payload.PullRequest.Draft
+}
+
+// WebhookResponse tells GitHub, and whoever reads its delivery log, what became
+// of a delivery.
+type WebhookResponse struct {
+	Event   string `json:"event"`
+	Handled bool   `json:"handled"`
+	Routed  bool   `json:"routed"`
+}
+
+func writeWebhookResponse(w http.ResponseWriter, response WebhookResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// webhookPinged confirms that a newly added webhook reaches the plugin, in the
+// log and in the channels subscribed to its repository. Organization webhooks
+// have no repository, so they are only logged. It reports whether any channel
+// was told.
+func (p *Plugin) webhookPinged(event *github.PingEvent, body []byte) bool {
+	var payload struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	json.Unmarshal(body, &payload)
+
+	source := payload.Repository.FullName
+	if source == "" {
+		source, _ = webhookOwner(body)
+	}
+	fmt.Printf("Webhook %v connected for %v\n", event.GetHookID(), source)
+
+	if payload.Repository.FullName == "" {
+		return false
+	}
+
+	subscriptions, err := NewSubscriptionsForRepositoryFromKVStore(p.api.KeyValueStore(), payload.Repository.FullName)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		return false
+	}
+	return p.notifySubscribers(subscriptions, subscriptions.GetSubscriptionsForRepository(payload.Repository.FullName),
+		fmt.Sprintf("GitHub webhook connected for **%v**.", payload.Repository.FullName))
+}
+
+// discussionEvent holds the parts of a discussion webhook payload that are
+// posted.
+type discussionEvent struct {
+	Action     string `json:"action"`
+	Discussion struct {
+		Number   int    `json:"number"`
+		Title    string `json:"title"`
+		HTMLURL  string `json:"html_url"`
+		Category struct {
+			Name  string `json:"name"`
+			Emoji string `json:"emoji"`
+		} `json:"category"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"discussion"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// discussionChanged posts newly created discussions to the channels subscribed
+// to their repository's discussions. It reports whether any channel got one.
+func (p *Plugin) discussionChanged(body []byte) bool {
+	var event discussionEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		fmt.Println("Error parsing discussion: " + err.Error())
+		return false
+	}
+	if event.Action != "created" {
+		return false
+	}
+
+	discussion := event.Discussion
+	message := fmt.Sprintf("**%v** started a discussion [%v#%v %v](%v)",
+		discussion.User.Login, event.Repository.FullName, discussion.Number, discussion.Title, discussion.HTMLURL)
+	if discussion.Category.Name != "" {
+		message += " in " + strings.TrimSpace(discussion.Category.Emoji+" "+discussion.Category.Name)
+	}
+
+	subscriptions, err := NewSubscriptionsForRepositoryFromKVStore(p.api.KeyValueStore(), event.Repository.FullName)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		return false
+	}
+
+	routed := false
+	for _, subscription := range subscriptions.GetSubscriptionsForEvent(event.Repository.FullName, EVENT_DISCUSSIONS) {
+		if !p.isInSubscriptionTeams(subscription) {
+			continue
+		}
+		if subscription.Digest != "" {
+			p.addToDigest(subscription.ChannelId, subscription.Digest, message)
+			routed = true
+			continue
+		}
+		if _, err := p.api.CreatePost(p.newPost(subscription.ChannelId, message)); err != nil {
+			fmt.Println("Error posting discussion: " + err.Error())
+			continue
+		}
+		p.metrics.incPosts()
+		routed = true
+	}
+	return routed
+}
+
+// refChanged posts a created or deleted branch or tag to the channels
+// subscribed to the repository's refs. It reports whether any channel got it.
+func (p *Plugin) refChanged(repo *github.Repository, sender *github.User, refType, ref string, created bool) bool {
+	if refType != "branch" && refType != "tag" {
+		return false
+	}
+
+	message := fmt.Sprintf("**%v** deleted %v `%v` in [%v](%v)", sender.GetLogin(), refType, ref, repo.GetFullName(), repo.GetHTMLURL())
+	if created {
+		message = fmt.Sprintf("**%v** created %v [%v](%v/tree/%v) in [%v](%v)", sender.GetLogin(), refType, ref, repo.GetHTMLURL(), ref, repo.GetFullName(), repo.GetHTMLURL())
+	}
+
+	subscriptions, err := NewSubscriptionsForRepositoryFromKVStore(p.api.KeyValueStore(), repo.GetFullName())
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		return false
+	}
+
+	routed := false
+	for _, subscription := range subscriptions.GetSubscriptionsForEvent(repo.GetFullName(), EVENT_REFS) {
+		if !p.isInSubscriptionTeams(subscription) {
+			continue
+		}
+		if subscription.Digest != "" {
+			p.addToDigest(subscription.ChannelId, subscription.Digest, message)
+			routed = true
+			continue
+		}
+		if _, err := p.api.CreatePost(p.newPost(subscription.ChannelId, message)); err != nil {
+			fmt.Println("Error posting ref change: " + err.Error())
+			continue
+		}
+		p.metrics.incPosts()
+		routed = true
+	}
+	return routed
+}
+
+// issueChanged posts an opened issue to the channels subscribed to issues, as
+// long as it is assigned to one of their assignees, if they have any. Channels
+// scoped to assignees also get issues assigned to one of them later on. It
+// reports whether any channel got it.
+func (p *Plugin) issueChanged(event *github.IssuesEvent) bool {
+	repo := event.GetRepo().GetFullName()
+	issue := event.GetIssue()
+
+	subscriptions, err := NewSubscriptionsForRepositoryFromKVStore(p.api.KeyValueStore(), repo)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		return false
+	}
+
+	assignees := githubUserListToUsernames(issue.Assignees)
+	message := fmt.Sprintf("**%v** opened [%v#%v %v](%v)", issue.GetUser().GetLogin(), repo, issue.GetNumber(), issue.GetTitle(), issue.GetHTMLURL())
+	if event.GetAction() == "assigned" {
+		assignees = []string{event.GetAssignee().GetLogin()}
+		message = fmt.Sprintf("**%v** assigned **%v** to [%v#%v %v](%v)", event.GetSender().GetLogin(), event.GetAssignee().GetLogin(), repo, issue.GetNumber(), issue.GetTitle(), issue.GetHTMLURL())
+	} else if len(assignees) > 0 {
+		message += " assigned to " + strings.Join(assignees, ", ")
+	}
+
+	routed := false
+	for _, subscription := range subscriptions.GetSubscriptionsForEvent(repo, EVENT_ISSUES) {
+		// Channels that take every issue already got it when it was opened.
+		if event.GetAction() == "assigned" && len(subscription.Assignees) == 0 {
+			continue
+		}
+		if !subscription.AllowsAssignees(assignees) || !p.isInSubscriptionTeams(subscription) {
+			continue
+		}
+		if subscription.Digest != "" {
+			p.addToDigest(subscription.ChannelId, subscription.Digest, message)
+			routed = true
+			continue
+		}
+		if _, err := p.api.CreatePost(p.newPost(subscription.ChannelId, message)); err != nil {
+			fmt.Println("Error posting issue: " + err.Error())
+			continue
+		}
+		p.metrics.incPosts()
+		routed = true
+	}
+	return routed
+}
+
+// webhookOwner returns the owner of the repository a delivery is routed by,
+// which picks the secret it must carry, or the organization for deliveries
+// without a repository. It returns false if the payload's organization isn't
+// the repository's owner, since the secret of one owner must not let a
+// delivery post as another's repository.
+func webhookOwner(body []byte) (string, bool) {
+	var payload struct {
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", true
+	}
+	if payload.Repository.FullName == "" {
+		return payload.Organization.Login, true
+	}
+
+	owner := strings.SplitN(payload.Repository.FullName, "/", 2)[0]
+	if payload.Organization.Login != "" && !strings.EqualFold(payload.Organization.Login, owner) {
+		return "", false
+	}
+	return owner, true
+}
+
+// isInSubscriptionTeams reports whether the subscription's channel belongs to
+// one of the teams the subscription is restricted to. Every channel does if it
+// isn't restricted.
+func (p *Plugin) isInSubscriptionTeams(subscription *Subscription) bool {
+	if len(subscription.Teams) == 0 {
+		return true
+	}
+	return containsString(subscription.Teams, p.channelTeamName(subscription.ChannelId))
+}
+
+// channelTeamName returns the lower case name of the channel's team, or an
+// empty string if it has none or it can't be found.
+func (p *Plugin) channelTeamName(channelId string) string {
+	channel, err := p.api.GetChannel(channelId)
+	if err != nil || channel.TeamId == "" {
+		return ""
+	}
+	team, err := p.api.GetTeam(channel.TeamId)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(team.Name)
+}
+
+// repositoryRenamedPayload holds the previous name of a renamed repository,
+// which go-github doesn't decode.
+type repositoryRenamedPayload struct {
+	Changes struct {
+		Repository struct {
+			Name struct {
+				From string `json:"from"`
+			} `json:"name"`
+		} `json:"repository"`
+	} `json:"changes"`
+}
+
+// repositoryChanged tells the channels subscribed to a repository that it was
+// renamed, archived or deleted, since they would otherwise stop getting
+// notifications without a hint as to why. Renamed repositories keep their
+// subscriptions if MigrateRenamedRepositories is set.
+func (p *Plugin) repositoryChanged(event *github.RepositoryEvent, body []byte) bool {
+	repo := event.GetRepo()
+
+	var message string
+	switch event.GetAction() {
+	case "renamed":
+		var payload repositoryRenamedPayload
+		if err := json.Unmarshal(body, &payload); err != nil || payload.Changes.Repository.Name.From == "" {
+			fmt.Printf("Unable to read the previous name of %v\n", repo.GetFullName())
+			return false
+		}
+		oldName := repo.GetOwner().GetLogin() + "/" + payload.Changes.Repository.Name.From
+		fmt.Printf("Repository %v was renamed to %v\n", oldName, repo.GetFullName())
+
+		subscriptions, err := NewSubscriptionsForRepositoryFromKVStore(p.api.KeyValueStore(), oldName)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+			return false
+		}
+		channels := subscriptions.GetSubscriptionsForRepository(oldName)
+
+		if p.config().MigrateRenamedRepositories {
+			if subscriptions.Rename(oldName, repo.GetFullName()) {
+				if err := subscriptions.StoreInKVStore(p.api.KeyValueStore()); err != nil {
+					fmt.Println("Error: " + err.Error())
+					return false
+				}
+			}
+			message = fmt.Sprintf("**%v** was renamed to [%v](%v). This channel's subscription has moved to the new name.", oldName, repo.GetFullName(), repo.GetHTMLURL())
+		} else {
+			message = fmt.Sprintf("**%v** was renamed to [%v](%v). Use `/github subscribe %v` to keep getting notifications.", oldName, repo.GetFullName(), repo.GetHTMLURL(), repo.GetFullName())
+		}
+		return p.notifySubscribers(subscriptions, channels, message)
+	case "archived":
+		message = fmt.Sprintf("[%v](%v) was archived, so there will be no more notifications from it.", repo.GetFullName(), repo.GetHTMLURL())
+	case "deleted":
+		message = fmt.Sprintf("**%v** was deleted, so there will be no more notifications from it.", repo.GetFullName())
+	default:
+		return false
+	}
+
+	fmt.Printf("Repository %v was %v\n", repo.GetFullName(), event.GetAction())
+
+	subscriptions, err := NewSubscriptionsForRepositoryFromKVStore(p.api.KeyValueStore(), repo.GetFullName())
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		return false
+	}
+	return p.notifySubscribers(subscriptions, subscriptions.GetSubscriptionsForRepository(repo.GetFullName()), message)
+}
+
+// notifySubscribers posts the message to each subscribed channel that isn't
+// muted. It reports whether any channel got it.
+func (p *Plugin) notifySubscribers(subscriptions *Subscriptions, channels []*Subscription, message string) bool {
+	routed := false
+	for _, subscription := range channels {
+		if subscriptions.IsMuted(subscription.ChannelId) || !p.isInSubscriptionTeams(subscription) {
+			continue
+		}
+		if _, err := p.api.CreatePost(p.newPost(subscription.ChannelId, message)); err != nil {
+			fmt.Println("Error posting repository change: " + err.Error())
+			continue
+		}
+		p.metrics.incPosts()
+		routed = true
+	}
+	return routed
+}
+
+func (p *Plugin) pullRequestOpened(repo string, pullRequest *github.PullRequest) bool {
+	subscriptions, err := NewSubscriptionsForRepositoryFromKVStore(p.api.KeyValueStore(), repo)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		return false
+	}
+
+	gob.Register([]map[string]string{})
+
+	values := strings.Split(repo, "/")
+
+	// The detailed post takes several GitHub calls to build, so it is only
+	// built once and only if a channel wants it. With AsyncReviewers, the
+	// reviewers are added to the posts once they have been made.
+	asyncReviewers := p.config().AsyncReviewers
+	var post *model.Post
+	var detailedPosts []*model.Post
+	routed := false
+	openedByBot := p.config().IsBot(pullRequest.GetUser())
+	// The pinned go-github has no labels on pull requests, so they are only
+	// fetched if a subscription mentions someone for them.
+	var labels []string
+	labelsFetched := false
+	mentionFor := func(subscription *Subscription) string {
+		if len(subscription.MentionLabels) == 0 {
+			return ""
+		}
+		if !labelsFetched {
+			labelsFetched = true
+			githubLabels, err := listIssueLabels(context.Background(), p.githubClient, values[0], values[1], pullRequest.GetNumber())
+			if err != nil {
+				fmt.Println("Error retrieving labels: " + err.Error())
+			}
+			for _, label := range githubLabels {
+				labels = append(labels, label.GetName())
+			}
+		}
+		if mention := subscription.MentionFor(labels); mention != "" {
+			return mention + " "
+		}
+		return ""
+	}
+	for _, subscription := range subscriptions.GetSubscriptionsForEvent(repo, EVENT_PULLS) {
+		if openedByBot && subscription.IgnoreBots {
+			continue
+		}
+		if !subscription.AllowsAuthor(pullRequest.GetUser().GetLogin()) || !subscription.AllowsBase(pullRequest.GetBase().GetRef()) {
+			continue
+		}
+		if !p.isInSubscriptionTeams(subscription) {
+			continue
+		}
+		mention := mentionFor(subscription)
+		if subscription.Digest != "" {
+			p.addToDigest(subscription.ChannelId, subscription.Digest, mention+compactPullRequestMessage(repo, pullRequest))
+			routed = true
+			continue
+		}
+
+		var channelPost model.Post
+		if subscription.GetFormat() == FORMAT_COMPACT {
+			channelPost = *p.newPost(subscription.ChannelId, mention+compactPullRequestMessage(repo, pullRequest))
+			if attachments := p.pullRequestActions(values[0], values[1], pullRequest.GetNumber(), pullRequestState(pullRequest)); attachments != nil {
+				channelPost.Props["attachments"] = attachments
+			}
+		} else {
+			if post == nil {
+				post = p.postFromPullRequest(values[0], values[1], pullRequest, !asyncReviewers)
+			}
+			channelPost = copyPost(post)
+			channelPost.ChannelId = subscription.ChannelId
+			channelPost.Message = mention + post.Message
+		}
+
+		created, err := p.api.CreatePost(&channelPost)
+		if err != nil {
+			fmt.Println("Error posting pull request: " + err.Error())
+			continue
+		}
+		p.metrics.incPosts()
+		routed = true
+		if channelPost.Type == PULL_REQUEST_POST_TYPE {
+			detailedPosts = append(detailedPosts, created)
+		}
+	}
+
+	if asyncReviewers && len(detailedPosts) > 0 {
+		p.runInBackground(func(ctx context.Context) {
+			p.addReviewersToPosts(values[0], values[1], pullRequest.GetNumber(), detailedPosts)
+		})
+	}
+	return routed
+}
+
+// addReviewersToPosts looks up the pull request's reviewers and adds them to
+// its detailed posts.
+func (p *Plugin) addReviewersToPosts(org, repository string, number int, posts []*model.Post) {
+	reviewers, ok := p.fetchReviewers(org, repository, number)
+	if !ok || len(reviewers) == 0 {
+		return
+	}
+	reviewers, more := limitList(reviewers, p.config().GetMaxListItems())
+
+	for _, post := range posts {
+		props := map[string]interface{}{}
+		for key, value := range post.Props {
+			props[key] = value
+		}
+		props["reviewers"] = reviewers
+		props["reviewers_more"] = more
+		post.Props = props
+
+		if _, err := p.api.UpdatePost(post); err != nil {
+			fmt.Println("Error adding reviewers to the post: " + err.Error())
+		}
+	}
+}
+
+// copyPost returns a copy of the post for another channel, with its own props,
+// so that nothing set on them while the post is created in one channel
+// carries over to the next.
+func copyPost(post *model.Post) model.Post {
+	channelPost := *post
+	channelPost.Props = make(model.StringInterface, len(post.Props))
+	for key, value := range post.Props {
+		channelPost.Props[key] = value
+	}
+	return channelPost
+}
+
+// compactPullRequestMessage describes an opened pull request in a single line.
+func compactPullRequestMessage(repo string, pullRequest *github.PullRequest) string {
+	message := fmt.Sprintf("**%v** opened [%v#%v %v](%v)", pullRequest.GetUser().GetLogin(), repo, pullRequest.GetNumber(), pullRequest.GetTitle(), pullRequest.GetHTMLURL())
+	if milestone := describeMilestone(pullRequest.GetMilestone()); milestone != "" {
+		message += " (" + milestone + ")"
+	}
+	return message
+}
+
+// reviewStateDescriptions describes the states of a submitted review. Webhook
+// payloads report them in lower case.
+var reviewStateDescriptions = map[string]string{
+	"approved":          "approved",
+	"changes_requested": "requested changes on",
+	"commented":         "commented on",
+}
+
+// pullRequestReviewed posts the outcome of a submitted review to the channels
+// subscribed to reviews and sends it to the pull request's author, if they
+// have connected their GitHub account. It reports whether any channel got it.
+func (p *Plugin) pullRequestReviewed(event *github.PullRequestReviewEvent) bool {
+	review := event.GetReview()
+	pullRequest := event.GetPullRequest()
+
+	description, ok := reviewStateDescriptions[strings.ToLower(review.GetState())]
+	if !ok {
+		return false
+	}
+
+	message := fmt.Sprintf("**%v** %v [%v#%v %v](%v)",
+		review.GetUser().GetLogin(), description, event.GetRepo().GetFullName(),
+		pullRequest.GetNumber(), pullRequest.GetTitle(), review.GetHTMLURL())
+
+	subscriptions, err := NewSubscriptionsForRepositoryFromKVStore(p.api.KeyValueStore(), event.GetRepo().GetFullName())
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		return false
+	}
+
+	routed := false
+	for _, subscription := range subscriptions.GetSubscriptionsForEvent(event.GetRepo().GetFullName(), EVENT_REVIEWS) {
+		if !subscription.AllowsBase(pullRequest.GetBase().GetRef()) || !p.isInSubscriptionTeams(subscription) {
+			continue
+		}
+		if subscription.Digest != "" {
+			p.addToDigest(subscription.ChannelId, subscription.Digest, message)
+			routed = true
+			continue
+		}
+		if _, err := p.api.CreatePost(p.newPost(subscription.ChannelId, message)); err != nil {
+			fmt.Println("Error posting review: " + err.Error())
+			continue
+		}
+		p.metrics.incPosts()
+		routed = true
+	}
+
+	author := pullRequest.GetUser().GetLogin()
+	if author == "" || strings.EqualFold(author, review.GetUser().GetLogin()) {
+		return routed
+	}
+	if userId := p.getUserIdForGitHubLogin(author); userId != "" {
+		p.sendDirectMessage(userId, message)
+	}
+	return routed
+}
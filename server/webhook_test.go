@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestPullRequestOpenedRoutesToSubscribedChannels(t *testing.T) {
+	api := &testAPI{}
+	p := newTestPlugin(api)
+	subscribeForTest(t, p, "owner/x", &Subscription{ChannelId: "channel-a", Format: FORMAT_COMPACT})
+	subscribeForTest(t, p, "owner/y", &Subscription{ChannelId: "channel-b", Format: FORMAT_COMPACT})
+	subscribeForTest(t, p, "owner/y", &Subscription{ChannelId: "channel-c", Format: FORMAT_COMPACT})
+
+	for _, tc := range []struct {
+		repo     string
+		channels []string
+	}{
+		{"owner/x", []string{"channel-a"}},
+		{"owner/y", []string{"channel-b", "channel-c"}},
+		{"Owner/X", []string{"channel-a"}},
+		{"owner/z", []string{}},
+		{"other/x", []string{}},
+	} {
+		api.posts = nil
+		pullRequest := &github.PullRequest{Number: github.Int(1), User: &github.User{Login: github.String("author")}}
+
+		routed := p.pullRequestOpened(tc.repo, pullRequest)
+
+		channels := api.postChannels()
+		sort.Strings(channels)
+		if !reflect.DeepEqual(channels, tc.channels) {
+			t.Errorf("%v: posted to %v, want %v", tc.repo, channels, tc.channels)
+		}
+		if routed != (len(tc.channels) > 0) {
+			t.Errorf("%v: routed is %v with %v channels", tc.repo, routed, len(tc.channels))
+		}
+	}
+}
+
+func TestPullRequestOpenedCopiesPropsPerChannel(t *testing.T) {
+	api := &testAPI{}
+	p := newTestPlugin(api)
+	client, server := newTestGitHubClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	p.githubClient = client
+	subscribeForTest(t, p, "owner/x", &Subscription{ChannelId: "channel-a"})
+	subscribeForTest(t, p, "owner/x", &Subscription{ChannelId: "channel-b"})
+
+	createdAt := time.Unix(1500000000, 0)
+	p.pullRequestOpened("owner/x", &github.PullRequest{Number: github.Int(1), CreatedAt: &createdAt})
+
+	if len(api.posts) != 2 {
+		t.Fatalf("made %v posts, want 2", len(api.posts))
+	}
+	api.posts[0].Props["changed"] = true
+	if _, ok := api.posts[1].Props["changed"]; ok {
+		t.Error("the posts of both channels share their props")
+	}
+}
+
+// newWebhookRequestForTest returns a delivery of the GitHub event to the path,
+// signed with the plugin's webhook secret.
+func newWebhookRequestForTest(p *Plugin, path, event, body string) *http.Request {
+	mac := hmac.New(sha1.New, []byte(p.config().WebhookSecret))
+	mac.Write([]byte(body))
+
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-GitHub-Event", event)
+	r.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+// deliverWebhookForTest delivers the GitHub event to the plugin's webhook
+// handler.
+func deliverWebhookForTest(p *Plugin, event, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	p.handleWebhook(w, newWebhookRequestForTest(p, p.webhookURLPath(), event, body))
+	return w
+}
+
+// pullRequestPayload returns a pull_request delivery about owner/x#1.
+func pullRequestPayload(action string, draft bool) string {
+	return fmt.Sprintf(`{
+		"action": %q,
+		"number": 1,
+		"pull_request": {"number": 1, "title": "Title", "draft": %v, "user": {"login": "author"}},
+		"repository": {"name": "x", "full_name": "owner/x", "owner": {"login": "owner"}}
+	}`, action, draft)
+}
+
+func TestHandleWebhookPostsOnlyOpenedPullRequests(t *testing.T) {
+	api := &testAPI{}
+	p := newTestPlugin(api)
+	subscribeForTest(t, p, "owner/x", &Subscription{ChannelId: "channel-a", Format: FORMAT_COMPACT})
+
+	for _, tc := range []struct {
+		action string
+		posts  int
+	}{
+		{"opened", 1},
+		{"edited", 0},
+		{"synchronize", 0},
+		{"labeled", 0},
+		{"assigned", 0},
+		{"closed", 0},
+	} {
+		api.posts = nil
+		w := deliverWebhookForTest(p, "pull_request", pullRequestPayload(tc.action, false))
+		if w.Code != http.StatusOK {
+			t.Errorf("%v: got status %v", tc.action, w.Code)
+		}
+		if len(api.posts) != tc.posts {
+			t.Errorf("%v: made %v posts, want %v", tc.action, len(api.posts), tc.posts)
+		}
+	}
+}
+
+func TestHandleWebhookRejectsMalformedBodies(t *testing.T) {
+	api := &testAPI{}
+	p := newTestPlugin(api)
+	subscribeForTest(t, p, "owner/x", &Subscription{ChannelId: "channel-a", Format: FORMAT_COMPACT})
+
+	for _, body := range []string{
+		`{`,
+		`not json`,
+		`{"action": 1, "pull_request": {"number": 1}}`,
+		`{"action": "opened", "pull_request": {"number": "one"}}`,
+	} {
+		w := deliverWebhookForTest(p, "pull_request", body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: got status %v, want %v", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if len(api.posts) != 0 {
+		t.Errorf("made %v posts", len(api.posts))
+	}
+}
+
+func TestHandleWebhookPostsDraftsWhenReady(t *testing.T) {
+	for _, tc := range []struct {
+		skipDrafts bool
+		action     string
+		draft      bool
+		posts      int
+	}{
+		{true, "opened", true, 0},
+		{true, "ready_for_review", false, 1},
+		{true, "opened", false, 1},
+		{true, "edited", true, 0},
+		{false, "opened", true, 1},
+		{false, "ready_for_review", false, 0},
+		{false, "opened", false, 1},
+	} {
+		api := &testAPI{}
+		p := newTestPlugin(api)
+		config := *p.config()
+		config.SkipDraftPullRequests = tc.skipDrafts
+		p.configuration.Store(&config)
+		subscribeForTest(t, p, "owner/x", &Subscription{ChannelId: "channel-a", Format: FORMAT_COMPACT})
+
+		deliverWebhookForTest(p, "pull_request", pullRequestPayload(tc.action, tc.draft))
+		if len(api.posts) != tc.posts {
+			t.Errorf("skipping drafts %v, %v with draft %v: made %v posts, want %v", tc.skipDrafts, tc.action, tc.draft, len(api.posts), tc.posts)
+		}
+	}
+}
+
+func TestServeHTTPWebhookPaths(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		token        string
+		acceptLegacy bool
+		path         string
+		status       int
+	}{
+		{"no token", "", false, "/webhook", http.StatusOK},
+		{"token", "token", false, "/webhook/token", http.StatusOK},
+		{"legacy path with a token", "token", false, "/webhook", http.StatusNotFound},
+		{"legacy path accepted", "token", true, "/webhook", http.StatusOK},
+		{"wrong token", "token", true, "/webhook/other", http.StatusNotFound},
+	} {
+		api := &testAPI{}
+		p := newTestPlugin(api)
+		if tc.token != "" {
+			api.kv.Set(WEBHOOK_TOKEN_KEY, []byte(tc.token))
+		}
+		config := *p.config()
+		config.AcceptLegacyWebhookPath = tc.acceptLegacy
+		p.configuration.Store(&config)
+
+		w := httptest.NewRecorder()
+		p.serveHTTP(w, newWebhookRequestForTest(p, tc.path, "pull_request", pullRequestPayload("closed", false)))
+		if w.Code != tc.status {
+			t.Errorf("%v: got status %v, want %v", tc.name, w.Code, tc.status)
+		}
+	}
+}
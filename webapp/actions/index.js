@@ -7,17 +7,22 @@ export function requestReviewers(postId, prId, reviewers, org, repo) {
     return async (dispatch, getState) => {
         const post = getPost(getState(), postId);
 
+        let result;
         try {
-            await Client.requestReviewers(prId, reviewers, org, repo);
+            // The post's channel is told who was asked to review.
+            result = await Client.requestReviewers(prId, reviewers, org, repo, [], post ? post.channel_id : '');
         } catch (error) {
             return {error};
         }
 
+        // Logins GitHub doesn't know are left out and reported back.
+        const invalidReviewers = (result && result.invalid_reviewers) || [];
+
         if (!post) {
-            return {data: true};
+            return {data: {invalidReviewers}};
         }
 
-        const props = {...(post.props || {}), reviewers};
+        const props = {...(post.props || {}), reviewers: (result && result.reviewers) || reviewers.filter((r) => !invalidReviewers.includes(r))};
 
         dispatch({
             type: PostTypes.RECEIVED_POSTS,
@@ -30,6 +35,6 @@ export function requestReviewers(postId, prId, reviewers, org, repo) {
             channelId: post.channel_id
         });
 
-        return {data: true};
+        return {data: {invalidReviewers}};
     };
 }
@@ -5,8 +5,8 @@ export default class Client {
         this.url = '/plugins/github/api/v1';
     }
 
-    requestReviewers = async (prId, reviewers, org, repo) => {
-        return this.doPost(`${this.url}/pr/reviewers`, {pull_request_id: prId, reviewers, org, repo});
+    requestReviewers = async (prId, reviewers, org, repo, teamReviewers = [], channelId = '') => {
+        return this.doPost(`${this.url}/pr/reviewers`, {pull_request_id: prId, reviewers, team_reviewers: teamReviewers, org, repo, channel_id: channelId});
     }
 
     removeReviewers = async (prId, reviewers, org, repo) => {
@@ -30,7 +30,7 @@ export default class Client {
 
             return response.body;
         } catch (err) {
-            throw err;
+            throw errorFromResponse(err);
         }
     }
 
@@ -47,7 +47,7 @@ export default class Client {
 
             return response.body;
         } catch (err) {
-            throw err;
+            throw errorFromResponse(err);
         }
     }
 
@@ -64,7 +64,18 @@ export default class Client {
 
             return response.body;
         } catch (err) {
-            throw err;
+            throw errorFromResponse(err);
         }
     }
 }
+
+// The server answers errors with {error, status}, so surface its message.
+function errorFromResponse(err) {
+    const body = err.response && err.response.body;
+    if (body && body.error) {
+        const error = new Error(body.error);
+        error.status = body.status;
+        return error;
+    }
+    return err;
+}
@@ -4,6 +4,7 @@ const {formatText} = window['text-formatting'];
 const {messageHtmlToComponent} = window['post-utils'];
 
 import {formatDate} from '../../utils/date_utils';
+import {textColorForBackground} from '../../utils/color_utils';
 
 import PropTypes from 'prop-types';
 import {makeStyleFromTheme} from 'mattermost-redux/utils/theme_utils';
@@ -58,7 +59,10 @@ export default class PostTypePullRequest extends React.PureComponent {
 
         this.state = { 
             showDropdown: false,
-            reviewers: []
+            reviewers: [],
+            requestedReviewers: [],
+            invalidReviewers: [],
+            error: ''
         };
     }
 
@@ -88,12 +92,70 @@ export default class PostTypePullRequest extends React.PureComponent {
         }
     }
 
-    onToggle = (showDropdown) => {
-        if (!showDropdown) {
-            const props = this.props.post.props || {};
-            this.props.actions.requestReviewers(this.props.post.id, props.pull_request_number, this.state.reviewers || [], props.org, props.repo);
+    buildMore = (count, style) => {
+        if (!count) {
+            return null;
         }
+
+        return (
+            <div className='row'>
+                <div
+                    style={style.reviewerName}
+                >
+                    {'+' + count + ' more'}
+                </div>
+            </div>
+        );
+    }
+
+    onToggle = async (showDropdown) => {
         this.setState({showDropdown});
+
+        // Reviewers are only requested when the dropdown closes with a
+        // selection other than the one last requested.
+        const reviewers = this.state.reviewers;
+        const requested = this.state.requestedReviewers;
+        if (showDropdown || (reviewers.length === requested.length && reviewers.every((r) => requested.includes(r)))) {
+            return;
+        }
+
+        const props = this.props.post.props || {};
+        const {data, error} = await this.props.actions.requestReviewers(this.props.post.id, props.number, reviewers, props.org, props.repo);
+        if (error) {
+            this.setState({error: error.message, invalidReviewers: []});
+            return;
+        }
+
+        // Logins GitHub doesn't know are dropped from the selection and
+        // listed below the reviewers.
+        const invalidReviewers = data.invalidReviewers;
+        const valid = reviewers.filter((r) => !invalidReviewers.includes(r));
+        this.setState({
+            reviewers: valid,
+            requestedReviewers: valid,
+            invalidReviewers,
+            error: ''
+        });
+    }
+
+    buildReviewersError = (style) => {
+        let error = this.state.error;
+        if (!error && this.state.invalidReviewers.length) {
+            error = 'Unknown GitHub users: ' + this.state.invalidReviewers.join(', ');
+        }
+        if (!error) {
+            return null;
+        }
+
+        return (
+            <div className='row'>
+                <div
+                    style={style.error}
+                >
+                    {error}
+                </div>
+            </div>
+        );
     }
 
     renderReviewerAction = (text) => {
@@ -185,7 +247,7 @@ export default class PostTypePullRequest extends React.PureComponent {
                 return (
                     <div className='row'>
                         <div
-                            style={{color: l.text_color, backgroundColor: l.bg_color, padding: '2px'}}
+                            style={{color: textColorForBackground(l.color), backgroundColor: '#' + l.color, padding: '2px'}}
                         >
                             {l.text}
                         </div>
@@ -205,15 +267,43 @@ export default class PostTypePullRequest extends React.PureComponent {
         }
     }
 
+    buildStats = (props, style) => {
+        if (props.changed_files == null) {
+            return null;
+        }
+
+        return (
+            <div>
+                <span style={style.additions}>{'+' + props.additions}</span>
+                {' '}
+                <span style={style.deletions}>{'\u2212' + props.deletions}</span>
+                {' in ' + props.changed_files + (props.changed_files === 1 ? ' file' : ' files')}
+            </div>
+        );
+    }
+
     buildMilestone = (props, style) => {
+        let milestone = 'None';
+        if (props.milestone) {
+            milestone = props.milestone_url ? <a href={props.milestone_url}>{props.milestone}</a> : props.milestone;
+        }
+
         return (
             <div>
                 <div
                     style={style.reviewerName}
                     className='row'
                 >
-                    {props.milestone || 'None'}
+                    {milestone}
                 </div>
+                {props.milestone_due_on ? (
+                    <div
+                        style={style.reviewerName}
+                        className='row'
+                    >
+                        {'Due ' + formatDate(new Date(props.milestone_due_on * 1000), this.props.useMilitaryTime)}
+                    </div>
+                ) : null}
             </div>
         );
     }
@@ -221,41 +311,28 @@ export default class PostTypePullRequest extends React.PureComponent {
     render() {
         const style = getStyle(this.props.theme);
         const post = {...this.props.post};
-        //const props = post.props || {};
-        /*post.message = `#### Summary
-Integration of team icon upload / get mechanics. Several additions in api, model, i18n and tests. Already existing code of profile image was taken into account.
-
-#### Ticket Link
-https://github.com/mattermost/mattermost-server/issues/7616
 
-#### Reference PR
-https://github.com/mattermost/mattermost-webapp/pull/796
-https://github.com/mattermost/mattermost-redux/pull/403
-https://github.com/mattermost/mattermost-api-reference/pull/334`;*/
-
-        const reviewers = this.state.reviewers.map((r) => ({name: r, state: 'R'}));
+        // The props follow the PullRequestProps schema documented in the server.
+        const postProps = post.props || {};
+        const requested = (postProps.reviewers || []).concat(this.state.reviewers.filter((r) => !(postProps.reviewers || []).includes(r)));
 
         const props = {
-            number: 123,
-            submitter_name: 'jwilander',
-            title: 'PLT-7567 - Integration of team icons',
-            reviewers: [
-                {name: 'cpanato', state: 'A'},
-                {name: 'crspeller', state: 'X'},
-                ...reviewers,
-            ],
-            assignees: [
-                {name: 'hmhealey'},
-            ],
-            labels: [
-                {text: '2: Dev Review', text_color: 'black', bg_color: 'orange'},
-            ],
-            submitted_at: '3 hours ago'
+            number: postProps.number,
+            submitter_name: postProps.author,
+            submitter_avatar_url: postProps.author_avatar_url,
+            title: postProps.title,
+            reviewers: requested.map((r) => ({name: r, state: 'R'})),
+            assignees: (postProps.assignees || []).map((a) => ({name: a})),
+            labels: postProps.labels || [],
+            reviewers_more: postProps.reviewers_more,
+            assignees_more: postProps.assignees_more,
+            labels_more: postProps.labels_more,
+            submitted_at: postProps.submitted_at ? formatDate(new Date(postProps.submitted_at * 1000), this.props.useMilitaryTime) : '',
+            milestone: postProps.milestone,
+            milestone_url: postProps.milestone_url,
+            milestone_due_on: postProps.milestone_due_on
         };
 
-        props.title = post.props.title
-        props.number = post.props.number
-
         const formattedText = formatText(post.props.summary || '');
 
         return (
@@ -264,8 +341,17 @@ https://github.com/mattermost/mattermost-api-reference/pull/334`;*/
                 style={style.content}
                 className='col-sm-8'
                 >
-                    <h2>{props.title + ' #' + props.number}</h2>
-                    <span>{props.submitter_name + ' submitted ' + props.submitted_at}</span>
+                    <h2><a href={postProps.html_url}>{props.title + ' #' + props.number}</a></h2>
+                    <span>
+                        {props.submitter_avatar_url ? (
+                            <img
+                                src={props.submitter_avatar_url}
+                                style={style.avatar}
+                            />
+                        ) : null}
+                        {props.submitter_name + ' submitted ' + props.submitted_at}
+                    </span>
+                    {this.buildStats(post.props, style)}
                     {messageHtmlToComponent(formattedText, false)}
                 </div>
                 <div
@@ -275,14 +361,18 @@ https://github.com/mattermost/mattermost-api-reference/pull/334`;*/
                     <div style={style.rightSection}>
                         {this.buildReviewersDropdown(props, style)}
                         {this.buildReviewers(props, style)}
+                        {this.buildMore(props.reviewers_more, style)}
+                        {this.buildReviewersError(style)}
                     </div>
                     <div style={style.rightSection}>
                         <strong className='row'>{'Assignees'}</strong>
                         {this.buildAssignees(props, style)}
+                        {this.buildMore(props.assignees_more, style)}
                     </div>
                     <div style={style.rightSection}>
                         <strong className='row'>{'Labels'}</strong>
                         {this.buildLabels(props, style)}
+                        {this.buildMore(props.labels_more, style)}
                     </div>
                     <div style={style.rightSection}>
                         <strong className='row'>{'Milestone'}</strong>
@@ -308,12 +398,28 @@ const getStyle = makeStyleFromTheme((theme) => {
         },
         rightSection: {
         },
+        additions: {
+            color: '#28a745'
+        },
+        deletions: {
+            color: '#cb2431'
+        },
+        avatar: {
+            width: '16px',
+            height: '16px',
+            borderRadius: '50%',
+            marginRight: '5px',
+            verticalAlign: 'text-bottom'
+        },
         reviewerName: {
             width: '90%'
         },
         reviewerState: {
             width: '10%'
         },
+        error: {
+            color: theme.errorTextColor
+        },
         container: {
             borderLeftStyle: 'solid',
             borderLeftWidth: '4px',
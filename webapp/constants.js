@@ -0,0 +1,4 @@
+// Custom post types, which must match the *_POST_TYPE constants in the server.
+export const PostTypes = {
+    PULL_REQUEST: 'custom_github_pull_request'
+};
@@ -0,0 +1,15 @@
+// Returns a text color that is readable on top of the given hex background
+// color, such as the 6 digit colors GitHub uses for labels.
+export function textColorForBackground(hexColor) {
+    const hex = (hexColor || '').replace('#', '');
+    if (hex.length !== 6) {
+        return 'black';
+    }
+
+    const r = parseInt(hex.substr(0, 2), 16);
+    const g = parseInt(hex.substr(2, 2), 16);
+    const b = parseInt(hex.substr(4, 2), 16);
+    const luminance = ((0.299 * r) + (0.587 * g) + (0.114 * b)) / 255;
+
+    return luminance > 0.5 ? 'black' : 'white';
+}